@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AgentDefinition describes how to launch an agent CLI and how to get it back
+// into a previous conversation.
+type AgentDefinition struct {
+	Command   string
+	Flags     []string
+	Continue  []string // resume the most recent conversation
+	Resume    []string // resume the conversation "{id}"
+	SessionID []string `toml:"session_id"` // start a conversation with the ID "{id}"
+	Hosts     []string // API hosts the agent needs under a network allowlist
+}
+
+// Built-in agent definitions, overridable under [agents.<name>] in the global config
+var agentDefinitions = map[string]AgentDefinition{
+	"claude": {
+		Command:   "claude",
+		Continue:  []string{"--continue"},
+		Resume:    []string{"--resume", "{id}"},
+		SessionID: []string{"--session-id", "{id}"},
+		Hosts:     []string{"api.anthropic.com", "statsig.anthropic.com", "console.anthropic.com"},
+	},
+	"codex": {
+		Command:  "codex",
+		Continue: []string{"resume", "--last"},
+		Hosts:    []string{"api.openai.com", "chatgpt.com", "auth.openai.com"},
+	},
+	"aider": {
+		Command:  "aider",
+		Continue: []string{"--restore-chat-history"},
+	},
+}
+
+func getAgentDefinition(name string, globalConfig *GlobalConfig) AgentDefinition {
+	def, ok := agentDefinitions[name]
+	if !ok {
+		def = AgentDefinition{Command: name}
+	}
+
+	// Claude flags predate agent definitions and stay configurable on their own
+	if name == "claude" {
+		def.Flags = globalConfig.ClaudeFlags
+	}
+
+	if override, ok := globalConfig.Agents[name]; ok {
+		if override.Command != "" {
+			def.Command = override.Command
+		}
+		if override.Flags != nil {
+			def.Flags = override.Flags
+		}
+		if override.Continue != nil {
+			def.Continue = override.Continue
+		}
+		if override.Resume != nil {
+			def.Resume = override.Resume
+		}
+		if override.SessionID != nil {
+			def.SessionID = override.SessionID
+		}
+		if override.Hosts != nil {
+			def.Hosts = override.Hosts
+		}
+	}
+	return def
+}
+
+// resumes reports whether the agent can get back into a given conversation:
+// it has to take the ID at start and on resume.
+func (d AgentDefinition) resumes() bool {
+	return len(d.SessionID) > 0 && len(d.Resume) > 0
+}
+
+// withID fills the conversation ID into an argument template.
+func withID(args []string, id string) []string {
+	filled := make([]string, len(args))
+	for i, arg := range args {
+		filled[i] = strings.ReplaceAll(arg, "{id}", id)
+	}
+	return filled
+}
+
+// newConversationID is a random UUID, as agents expect for session IDs.
+func newConversationID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// agentCommand builds the command line for the project's agent. mode is "",
+// "continue" or "resume"; conversation is the agent's conversation ID to
+// start ("") or resume ("resume"), if it takes one.
+func agentCommand(config *Config, globalConfig *GlobalConfig, mode, conversation string, extraArgs []string) []string {
+	def := getAgentDefinition(config.Project.Agent, globalConfig)
+
+	agentCmd := []string{def.Command}
+	switch mode {
+	case "continue":
+		agentCmd = append(agentCmd, def.Continue...)
+	case "resume":
+		agentCmd = append(agentCmd, withID(def.Resume, conversation)...)
+	default:
+		if conversation != "" {
+			agentCmd = append(agentCmd, withID(def.SessionID, conversation)...)
+		}
+	}
+	agentCmd = append(agentCmd, def.Flags...)
+	return append(agentCmd, extraArgs...)
+}
+
+// rootSession follows the resume chain back to the session that started it.
+func rootSession(session *Session) string {
+	if session.ResumedFrom != "" {
+		return session.ResumedFrom
+	}
+	return session.ID
+}
+
+func resumeSession(args []string) {
+	cwd, _ := os.Getwd()
+	sessions, err := listSessions()
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to read history:", err)
+	}
+
+	var recent []*Session
+	for _, session := range sessions {
+		if session.Path == cwd && session.Kind == "agent" {
+			recent = append(recent, session)
+		}
+	}
+	if len(recent) == 0 {
+		fmt.Println("\033[31m✗\033[0m No agent sessions to resume for this project")
+		os.Exit(1)
+	}
+
+	var extraArgs []string
+	id := ""
+	for _, arg := range args {
+		if arg == "--last" || arg == "-l" {
+			id = recent[0].ID
+		} else if id == "" && !strings.HasPrefix(arg, "-") {
+			id = arg
+		} else {
+			extraArgs = append(extraArgs, arg)
+		}
+	}
+
+	var target *Session
+	switch {
+	case id != "":
+		for _, session := range recent {
+			if session.ID == id {
+				target = session
+			}
+		}
+		if target == nil {
+			fmt.Printf("\033[31m✗\033[0m Unknown session: %s\n", id)
+			os.Exit(1)
+		}
+	case len(recent) == 1 || !isTerminal(os.Stdin):
+		target = recent[0]
+	default:
+		// Only offer the sessions that can actually be resumed
+		choices := []*Session{recent[0]}
+		for _, session := range recent[1:] {
+			if session.Conversation != "" {
+				choices = append(choices, session)
+			}
+		}
+		target = recent[0]
+		if len(choices) > 1 {
+			target = pickSession(choices)
+		}
+	}
+
+	// Sessions that know their conversation resume it exactly, otherwise only
+	// the newest one can be picked up with the agent's "continue"
+	mode := "continue"
+	if target.Conversation != "" {
+		mode = "resume"
+	} else if target.ID != recent[0].ID {
+		fmt.Printf("\033[31m✗\033[0m Session %s has no conversation ID to resume, only the latest session can be continued\n", target.ID)
+		os.Exit(1)
+	}
+	fmt.Printf("\033[35m◉\033[0m Resuming session \033[36m%s\033[0m\n", target.ID)
+	launchAgent(extraArgs, target, mode)
+}
+
+func pickSession(sessions []*Session) *Session {
+	if len(sessions) > 9 {
+		sessions = sessions[:9]
+	}
+
+	fmt.Println("\033[33mRecent sessions:\033[0m")
+	for i, session := range sessions {
+		status := "running"
+		if !session.EndedAt.IsZero() {
+			status = fmt.Sprintf("%s, exit %d", formatDuration(session.EndedAt.Sub(session.StartedAt)), session.ExitCode)
+		}
+		fmt.Printf("  \033[36m%d\033[0m) %s  %s  \033[90m%s\033[0m\n",
+			i+1, session.ID, session.StartedAt.Format("2006-01-02 15:04"), status)
+	}
+	fmt.Print("Select session [1]: ")
+
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return sessions[0]
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil || n < 1 || n > len(sessions) {
+		fmt.Println("\033[31m✗\033[0m Invalid selection")
+		os.Exit(1)
+	}
+	return sessions[n-1]
+}
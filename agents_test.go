@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestAgentCommand(t *testing.T) {
+	globalConfig := &GlobalConfig{}
+	globalConfig.ClaudeFlags = []string{"--dangerously-skip-permissions"}
+	globalConfig.Agents = map[string]AgentDefinition{
+		"aider": {Command: "aider-chat", Flags: []string{"--yes"}},
+	}
+	tests := []struct {
+		agent        string
+		mode         string
+		conversation string
+		extra        []string
+		want         []string
+	}{
+		{"claude", "", "", nil, []string{"claude", "--dangerously-skip-permissions"}},
+		{"claude", "", "", []string{"-p", "hi"}, []string{"claude", "--dangerously-skip-permissions", "-p", "hi"}},
+		{"claude", "", "c0ffee", nil, []string{"claude", "--session-id", "c0ffee", "--dangerously-skip-permissions"}},
+		{"claude", "continue", "", nil, []string{"claude", "--continue", "--dangerously-skip-permissions"}},
+		{"claude", "resume", "c0ffee", nil, []string{"claude", "--resume", "c0ffee", "--dangerously-skip-permissions"}},
+		{"codex", "continue", "", nil, []string{"codex", "resume", "--last"}},
+		// An agent without session_id ignores the conversation
+		{"codex", "", "c0ffee", nil, []string{"codex"}},
+		// Overrides replace only what they set
+		{"aider", "continue", "", nil, []string{"aider-chat", "--restore-chat-history", "--yes"}},
+		// Unknown agents run as is and have nothing to resume with
+		{"goose", "continue", "", nil, []string{"goose"}},
+	}
+	for _, tt := range tests {
+		config := &Config{}
+		config.Project.Agent = tt.agent
+		if got := agentCommand(config, globalConfig, tt.mode, tt.conversation, tt.extra); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q %q: %q, want %q", tt.agent, tt.mode, tt.conversation, got, tt.want)
+		}
+	}
+}
+
+func TestAgentResumes(t *testing.T) {
+	globalConfig := &GlobalConfig{}
+	globalConfig.Agents = map[string]AgentDefinition{
+		"codex": {SessionID: []string{"--id", "{id}"}, Resume: []string{"resume", "{id}"}},
+	}
+	tests := []struct {
+		agent string
+		want  bool
+	}{
+		{"claude", true},
+		{"codex", true},
+		{"aider", false},
+		{"goose", false},
+	}
+	for _, tt := range tests {
+		if got := getAgentDefinition(tt.agent, globalConfig).resumes(); got != tt.want {
+			t.Errorf("%s resumes = %v, want %v", tt.agent, got, tt.want)
+		}
+	}
+}
+
+func TestNewConversationID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	a, b := newConversationID(), newConversationID()
+	if !uuid.MatchString(a) {
+		t.Errorf("%q isn't a version 4 UUID", a)
+	}
+	if a == b {
+		t.Errorf("two conversations got the same ID %q", a)
+	}
+}
+
+func TestRootSession(t *testing.T) {
+	if got := rootSession(&Session{ID: "a"}); got != "a" {
+		t.Errorf("root of a fresh session = %q, want a", got)
+	}
+	if got := rootSession(&Session{ID: "b", ResumedFrom: "a"}); got != "a" {
+		t.Errorf("root of a resumed session = %q, want a", got)
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one command executed inside the container.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"` // "shell" (hooks), "exec" (tracer) or "viber00t"
+	Command  string    `json:"command"`
+	Argv     []string  `json:"argv,omitempty"`
+	Cwd      string    `json:"cwd,omitempty"`
+	PID      int       `json:"pid,omitempty"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+	Signal   string    `json:"signal,omitempty"`
+}
+
+const (
+	containerAuditSock = "/run/viber00t/audit.sock"
+	containerAuditHook = "/etc/viber00t/audit.sh"
+	// Entries come from inside the container, so keep a flood from filling the disk
+	maxAuditEntries = 100000
+)
+
+// auditHookScript reports shell commands through the viber00t helper. It is
+// sourced by interactive shells via bash.bashrc and by "bash -c" via BASH_ENV.
+const auditHookScript = `# viber00t audit hooks: report shell commands to the session audit log
+if [ -n "$VIBER00T_AUDIT_SOCK" ] && [ -n "$BASH_VERSION" ] && [ -z "$__viber00t_audit" ]; then
+    __viber00t_audit=1
+    if [[ $- == *i* ]]; then
+        __viber00t_audit_prompt() {
+            local code=$? entry
+            entry=$(HISTTIMEFORMAT= builtin history 1)
+            # The first prompt only sees the history file, not a command
+            if [ -n "$__viber00t_audit_primed" ] && [ -n "$entry" ] && [ "$entry" != "$__viber00t_audit_last" ]; then
+                TOOL _audit "$code" "$(echo "$entry" | sed 's/^ *[0-9]* *//')" 2>/dev/null
+            fi
+            __viber00t_audit_primed=1
+            __viber00t_audit_last=$entry
+            return $code
+        }
+        PROMPT_COMMAND="__viber00t_audit_prompt${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+    elif [ -n "$BASH_EXECUTION_STRING" ]; then
+        trap 'TOOL _audit "$?" "$BASH_EXECUTION_STRING" 2>/dev/null' EXIT
+    fi
+fi
+`
+
+func auditEnabled(config *Config, globalConfig *GlobalConfig) bool {
+	if config.Audit.Enabled != nil {
+		return *config.Audit.Enabled
+	}
+	return globalConfig.Audit.Enabled
+}
+
+// auditDockerfile installs the shell hooks; the script is written next to
+// the Dockerfile by writeAuditHook.
+func auditDockerfile() string {
+	return `
+# Audit hooks for interactive shells (bash -c gets them through BASH_ENV)
+COPY audit.sh ` + containerAuditHook + `
+RUN echo '[ -f ` + containerAuditHook + ` ] && . ` + containerAuditHook + `' >> /etc/bash.bashrc
+`
+}
+
+func writeAuditHook(buildDir string) error {
+	script := strings.ReplaceAll(auditHookScript, "TOOL", containerToolPath)
+	return ioutil.WriteFile(filepath.Join(buildDir, "audit.sh"), []byte(script), 0644)
+}
+
+// auditMounts describes the audit socket. sessionDir is empty when only describing.
+func auditMounts(enabled bool, sessionDir string) []Mount {
+	if !enabled {
+		return nil
+	}
+	if sessionDir == "" {
+		sessionDir = "<session>"
+	}
+	return []Mount{{
+		Name:   "audit",
+		Source: filepath.Join(sessionDir, "audit.sock"),
+		Target: containerAuditSock,
+		Mode:   "rw",
+		Reason: "command audit log",
+	}}
+}
+
+// startAuditLog collects entries from the container into the session's
+// audit.jsonl. The file stays on the host, out of the agent's reach, but what
+// goes in is reported by the container: only the time is the host's own.
+func startAuditLog(session *Session) ([]string, error) {
+	sock := filepath.Join(session.Dir(), "audit.sock")
+	os.Remove(sock)
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start audit log: %w", err)
+	}
+	os.Chmod(sock, 0600)
+
+	session.Audit = filepath.Join(session.Dir(), "audit.jsonl")
+	f, err := os.OpenFile(session.Audit, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	session.onFinish(func() {
+		listener.Close()
+		f.Close()
+	})
+
+	var mu sync.Mutex
+	written := 0
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+				for scanner.Scan() {
+					var entry AuditEntry
+					if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+						continue
+					}
+					entry.Time = time.Now()
+					entry.Command = redact(entry.Command)
+					entry.Argv = redactAll(entry.Argv)
+					mu.Lock()
+					if written == maxAuditEntries {
+						entry = AuditEntry{Time: entry.Time, Source: "viber00t", Command: "audit log full, dropping further entries"}
+					}
+					if written <= maxAuditEntries {
+						line, _ := json.Marshal(entry)
+						f.Write(append(line, '\n'))
+						written++
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+
+	return []string{
+		"-e", "VIBER00T_AUDIT_SOCK=" + containerAuditSock,
+		"-e", "BASH_ENV=" + containerAuditHook,
+	}, nil
+}
+
+func sendAudit(conn net.Conn, entry AuditEntry) {
+	line, _ := json.Marshal(entry)
+	conn.Write(append(line, '\n'))
+}
+
+// auditShellHook runs inside the container as "viber00t _audit <exit> <command>".
+func auditShellHook(args []string) {
+	if len(args) < 2 {
+		os.Exit(2)
+	}
+	sock := os.Getenv("VIBER00T_AUDIT_SOCK")
+	if sock == "" {
+		sock = containerAuditSock
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	code, _ := strconv.Atoi(args[0])
+	cwd, _ := os.Getwd()
+	sendAudit(conn, AuditEntry{
+		Time:     time.Now(),
+		Source:   "shell",
+		Command:  args[1],
+		Cwd:      cwd,
+		PID:      os.Getppid(),
+		ExitCode: &code,
+	})
+}
+
+// tracedCommand runs args under strace, reporting every successful execve
+// in the process tree to the audit socket. The returned function waits for
+// the last entries to be sent once the command has exited. It returns a nil
+// command when tracing is unavailable so the caller can run args directly.
+func tracedCommand(args []string) (*exec.Cmd, func()) {
+	strace, err := exec.LookPath("strace")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "viber00t: strace not found, only shell commands are audited")
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", containerAuditSock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "viber00t: audit log unavailable: %v\n", err)
+		return nil, nil
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		conn.Close()
+		return nil, nil
+	}
+
+	traceArgs := []string{"-f", "-q", "-ttt", "-s", "1024", "-e", "trace=execve", "-e", "signal=none", "-o", "/dev/fd/3", "--"}
+	cmd := exec.Command(strace, append(traceArgs, args...)...)
+	cmd.ExtraFiles = []*os.File{writer}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer conn.Close()
+		defer reader.Close()
+		tracer := newExecTracer(func(entry AuditEntry) { sendAudit(conn, entry) })
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			tracer.line(scanner.Text())
+		}
+		tracer.flush()
+	}()
+
+	drain := func() {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+		}
+	}
+	return cmd, drain
+}
+
+// execTracer turns strace output into audit entries, pairing each exec with
+// the exit status of its process.
+type execTracer struct {
+	emit       func(AuditEntry)
+	running    map[int]*AuditEntry
+	unfinished map[int]*AuditEntry
+}
+
+func newExecTracer(emit func(AuditEntry)) *execTracer {
+	return &execTracer{emit: emit, running: map[int]*AuditEntry{}, unfinished: map[int]*AuditEntry{}}
+}
+
+// line handles one strace line: "PID SECONDS.MICROS event".
+func (t *execTracer) line(line string) {
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(fields) != 2 {
+		return
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return
+	}
+	rest := strings.TrimLeft(fields[1], " ")
+	fields = strings.SplitN(rest, " ", 2)
+	if len(fields) != 2 {
+		return
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return
+	}
+	when := time.Unix(0, int64(seconds*1e9))
+	event := fields[1]
+
+	switch {
+	case strings.HasPrefix(event, "execve("):
+		entry, err := parseExecve(event)
+		if err != nil {
+			return
+		}
+		entry.Time, entry.PID = when, pid
+		if strings.HasSuffix(event, "<unfinished ...>") {
+			t.unfinished[pid] = entry
+			return
+		}
+		if execSucceeded(event) {
+			t.started(entry)
+		}
+	case strings.HasPrefix(event, "<... execve resumed>"):
+		if entry, ok := t.unfinished[pid]; ok {
+			delete(t.unfinished, pid)
+			if execSucceeded(event) {
+				t.started(entry)
+			}
+		}
+	case strings.HasPrefix(event, "+++ exited with "):
+		code, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(event, "+++ exited with "), " +++"))
+		if err == nil {
+			t.exited(pid, &code, "")
+		}
+	case strings.HasPrefix(event, "+++ killed by "):
+		signal := strings.Fields(strings.TrimPrefix(event, "+++ killed by "))
+		if len(signal) > 0 {
+			t.exited(pid, nil, signal[0])
+		}
+	}
+}
+
+func execSucceeded(event string) bool {
+	return strings.HasSuffix(strings.TrimSpace(event), "= 0")
+}
+
+func (t *execTracer) started(entry *AuditEntry) {
+	// A process that execs again replaces its previous program
+	if previous, ok := t.running[entry.PID]; ok {
+		t.emit(*previous)
+	}
+	if cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", entry.PID)); err == nil {
+		entry.Cwd = cwd
+	}
+	t.running[entry.PID] = entry
+}
+
+func (t *execTracer) exited(pid int, code *int, signal string) {
+	entry, ok := t.running[pid]
+	if !ok {
+		return
+	}
+	delete(t.running, pid)
+	entry.ExitCode, entry.Signal = code, signal
+	t.emit(*entry)
+}
+
+func (t *execTracer) flush() {
+	for pid, entry := range t.running {
+		delete(t.running, pid)
+		t.emit(*entry)
+	}
+}
+
+// parseExecve reads the path and argv from
+// execve("/bin/ls", ["ls", "-l"], 0x7ffd... /* 12 vars */) = 0
+func parseExecve(event string) (*AuditEntry, error) {
+	rest := strings.TrimPrefix(event, "execve(")
+	path, rest, err := parseCString(rest)
+	if err != nil {
+		return nil, err
+	}
+	rest = strings.TrimPrefix(rest, ", ")
+	if !strings.HasPrefix(rest, "[") {
+		return nil, errors.New("execve without argv")
+	}
+	rest = rest[1:]
+
+	var argv []string
+	for !strings.HasPrefix(rest, "]") {
+		if strings.HasPrefix(rest, "...") {
+			argv = append(argv, "...")
+			break
+		}
+		var arg string
+		if arg, rest, err = parseCString(rest); err != nil {
+			return nil, err
+		}
+		argv = append(argv, arg)
+		rest = strings.TrimPrefix(rest, ", ")
+	}
+
+	command := strings.Join(argv, " ")
+	if command == "" {
+		command = path
+	}
+	return &AuditEntry{Source: "exec", Command: command, Argv: argv}, nil
+}
+
+// parseCString reads a strace quoted string, including the "..." marking truncation.
+func parseCString(s string) (string, string, error) {
+	if !strings.HasPrefix(s, "\"") {
+		return "", "", errors.New("expected string")
+	}
+	var out strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			rest := s[i+1:]
+			if strings.HasPrefix(rest, "...") {
+				out.WriteString("...")
+				rest = rest[3:]
+			}
+			return out.String(), rest, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", "", errors.New("bad escape")
+			}
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case 'x':
+				if i+2 < len(s) {
+					if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+						out.WriteByte(byte(b))
+						i += 2
+					}
+				}
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				end := i + 1
+				for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+					end++
+				}
+				b, _ := strconv.ParseUint(s[i:end], 8, 8)
+				out.WriteByte(byte(b))
+				i = end - 1
+			default:
+				out.WriteByte(e)
+			}
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+func showAudit(args []string) {
+	asJSON := false
+	failed := false
+	source := ""
+	grep := ""
+	id := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--json":
+			asJSON = true
+		case arg == "--failed":
+			failed = true
+		case (arg == "--source" || arg == "--grep" || arg == "-g") && i+1 < len(args):
+			i++
+			if arg == "--source" {
+				source = args[i]
+			} else {
+				grep = args[i]
+			}
+		case strings.HasPrefix(arg, "--source="):
+			source = strings.TrimPrefix(arg, "--source=")
+		case strings.HasPrefix(arg, "--grep="):
+			grep = strings.TrimPrefix(arg, "--grep=")
+		default:
+			id = arg
+		}
+	}
+
+	var session *Session
+	var err error
+	if id != "" {
+		session, err = loadSession(id)
+	} else {
+		cwd, _ := os.Getwd()
+		session, err = latestSession(cwd)
+	}
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+
+	f, err := os.Open(filepath.Join(session.Dir(), "audit.jsonl"))
+	if err != nil {
+		log.Fatalf("\033[31m✗\033[0m No audit log for session %s (enable [audit] in Viber00t.toml)", session.ID)
+	}
+	defer f.Close()
+
+	shown := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry AuditEntry
+			if json.Unmarshal(line, &entry) == nil && auditMatches(entry, source, grep, failed) {
+				shown++
+				if asJSON {
+					os.Stdout.Write(line)
+				} else {
+					printAuditEntry(entry)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal("\033[31m✗\033[0m ", err)
+		}
+	}
+	if shown == 0 && !asJSON {
+		fmt.Println("\033[90mNo matching commands\033[0m")
+	} else if !asJSON {
+		fmt.Println("\033[90mEntries are reported by the container itself; only the times are taken on the host\033[0m")
+	}
+}
+
+func auditMatches(entry AuditEntry, source, grep string, failed bool) bool {
+	if source != "" && entry.Source != source {
+		return false
+	}
+	if grep != "" && !strings.Contains(entry.Command, grep) && !strings.Contains(entry.Cwd, grep) {
+		return false
+	}
+	if failed && (entry.ExitCode == nil || *entry.ExitCode == 0) && entry.Signal == "" {
+		return false
+	}
+	return true
+}
+
+func printAuditEntry(entry AuditEntry) {
+	status := "\033[90m  ?\033[0m"
+	switch {
+	case entry.Signal != "":
+		status = "\033[31m" + entry.Signal + "\033[0m"
+	case entry.ExitCode != nil && *entry.ExitCode == 0:
+		status = "\033[32m  ✓\033[0m"
+	case entry.ExitCode != nil:
+		status = fmt.Sprintf("\033[31m%3d\033[0m", *entry.ExitCode)
+	}
+	fmt.Printf("%s %s \033[90m%-5s %s\033[0m $ %s\n",
+		entry.Time.Local().Format("15:04:05"), status, entry.Source, entry.Cwd, entry.Command)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCString(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		value   string
+		rest    string
+		wantErr bool
+	}{
+		{"plain", `"ls", "-l"]`, "ls", `, "-l"]`, false},
+		{"empty", `""]`, "", "]", false},
+		{"escapes", `"a\nb\tc\"d\\e"`, "a\nb\tc\"d\\e", "", false},
+		{"hex", `"\x41\x42"`, "AB", "", false},
+		{"octal", `"\0\33[0m\177"`, "\x00\x1b[0m\x7f", "", false},
+		{"truncated", `"very long"..., "next"`, "very long...", `, "next"`, false},
+		{"not a string", `NULL`, "", "", true},
+		{"unterminated", `"abc`, "", "", true},
+		{"dangling escape", `"abc\`, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, rest, err := parseCString(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if value != tt.value || rest != tt.rest {
+				t.Errorf("got %q, %q, want %q, %q", value, rest, tt.value, tt.rest)
+			}
+		})
+	}
+}
+
+func TestParseExecve(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		command string
+		argv    []string
+		wantErr bool
+	}{
+		{
+			name:    "simple",
+			event:   `execve("/bin/ls", ["ls", "-l"], 0x7ffd2c2a8f08 /* 12 vars */) = 0`,
+			command: "ls -l",
+			argv:    []string{"ls", "-l"},
+		},
+		{
+			name:    "quoted arguments",
+			event:   `execve("/bin/sh", ["sh", "-c", "echo \"hi\" > /tmp/x"], 0x55d0 /* 3 vars */) = 0`,
+			command: `sh -c echo "hi" > /tmp/x`,
+			argv:    []string{"sh", "-c", `echo "hi" > /tmp/x`},
+		},
+		{
+			name:    "empty argv falls back to the path",
+			event:   `execve("/usr/bin/true", [], 0x7ffd /* 0 vars */) = 0`,
+			command: "/usr/bin/true",
+		},
+		{
+			name:    "truncated argv",
+			event:   `execve("/usr/bin/cc", ["cc", "-o", "out", ...], 0x7ffd /* 40 vars */) = 0`,
+			command: "cc -o out ...",
+			argv:    []string{"cc", "-o", "out", "..."},
+		},
+		{
+			name:    "unfinished",
+			event:   `execve("/bin/true", ["true"], 0x7ffd /* 1 var */ <unfinished ...>`,
+			command: "true",
+			argv:    []string{"true"},
+		},
+		{
+			name:    "no argv",
+			event:   `execve("/bin/true", NULL, NULL) = -1 EFAULT (Bad address)`,
+			wantErr: true,
+		},
+		{
+			name:    "garbage",
+			event:   `execve(0x1234, ...)`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := parseExecve(tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if entry.Source != "exec" || entry.Command != tt.command || !reflect.DeepEqual(entry.Argv, tt.argv) {
+				t.Errorf("got %s %q %q, want exec %q %q", entry.Source, entry.Command, entry.Argv, tt.command, tt.argv)
+			}
+		})
+	}
+}
+
+func TestExecTracer(t *testing.T) {
+	// PIDs far beyond pid_max so no cwd is picked up from /proc
+	lines := []string{
+		`900000001 1700000000.000001 execve("/bin/sh", ["sh", "-c", "make"], 0x1 /* 3 vars */) = 0`,
+		`900000002 1700000000.000002 execve("/usr/bin/make", ["make"], 0x1 /* 3 vars */ <unfinished ...>`,
+		`900000003 1700000000.000003 execve("/nope", ["nope"], 0x1 /* 3 vars */) = -1 ENOENT (No such file or directory)`,
+		`900000002 1700000000.000004 <... execve resumed>) = 0`,
+		`900000002 1700000000.000005 +++ exited with 2 +++`,
+		`900000004 1700000000.000006 execve("/bin/sleep", ["sleep", "60"], 0x1 /* 3 vars */) = 0`,
+		`900000004 1700000000.000007 +++ killed by SIGTERM +++`,
+		`900000001 1700000000.000008 execve("/bin/true", ["true"], 0x1 /* 3 vars */) = 0`,
+		`not a strace line`,
+	}
+
+	var entries []AuditEntry
+	tracer := newExecTracer(func(entry AuditEntry) { entries = append(entries, entry) })
+	for _, line := range lines {
+		tracer.line(line)
+	}
+	tracer.flush()
+
+	type result struct {
+		Command  string
+		ExitCode int
+		Signal   string
+	}
+	want := []result{
+		{"make", 2, ""},
+		{"sleep 60", -1, "SIGTERM"},
+		{"sh -c make", -1, ""}, // replaced by its own exec of true
+		{"true", -1, ""},       // still running when the trace ended
+	}
+	var got []result
+	for _, entry := range entries {
+		code := -1
+		if entry.ExitCode != nil {
+			code = *entry.ExitCode
+		}
+		got = append(got, result{entry.Command, code, entry.Signal})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries %+v, want %+v", got, want)
+	}
+}
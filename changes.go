@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileEntry describes one file of the project mount.
+type FileEntry struct {
+	Hash    string      `json:"hash"`
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime int64       `json:"mtime"`
+	Binary  bool        `json:"binary,omitempty"`
+}
+
+// Manifest maps slash-separated paths relative to the project root to their entries.
+type Manifest map[string]FileEntry
+
+// FileChange is a single line of a change report.
+type FileChange struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Binary  bool        `json:"binary,omitempty"`
+	OldMode os.FileMode `json:"old_mode,omitempty"`
+	NewMode os.FileMode `json:"new_mode,omitempty"`
+}
+
+// ChangeReport summarizes what a session did to the project.
+type ChangeReport struct {
+	Session  string       `json:"session"`
+	Project  string       `json:"project"`
+	Added    []FileChange `json:"added"`
+	Modified []FileChange `json:"modified"`
+	Deleted  []FileChange `json:"deleted"`
+}
+
+// projectFiles lists the files of dir that are not ignored. Git's own rules are
+// used inside a work tree, otherwise the top-level .gitignore is applied.
+func projectFiles(dir string) ([]string, error) {
+	// The project's fsmonitor hook would otherwise run on the host
+	cmd := exec.Command("git", "-C", dir, "-c", "core.fsmonitor=false", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if out, err := cmd.Output(); err == nil {
+		var files []string
+		seen := map[string]bool{}
+		for _, name := range strings.Split(string(out), "\x00") {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+		return files, nil
+	}
+
+	ignore := readGitignore(filepath.Join(dir, ".gitignore"))
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if info.Name() == ".git" || gitignoreMatch(ignore, rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func readGitignore(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns
+}
+
+// gitignoreMatch implements the common subset of gitignore patterns:
+// basename globs, anchored paths and directory-only patterns.
+func gitignoreMatch(patterns []string, rel string, isDir bool) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		if strings.Contains(strings.TrimPrefix(pattern, "/"), "/") || strings.HasPrefix(pattern, "/") {
+			if ok, _ := filepath.Match(strings.TrimPrefix(pattern, "/"), rel); ok {
+				return true
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// buildManifest hashes every file of dir. Entries from previous whose size and
+// modification time are unchanged are reused instead of being rehashed.
+func buildManifest(dir string, previous Manifest) (Manifest, error) {
+	files, err := projectFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := Manifest{}
+	for _, name := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		info, err := os.Lstat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		if prev, ok := previous[name]; ok && prev.Size == info.Size() && prev.ModTime == info.ModTime().UnixNano() && prev.Mode == info.Mode() {
+			manifest[name] = prev
+			continue
+		}
+
+		entry := FileEntry{Mode: info.Mode(), Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, _ := os.Readlink(path)
+			sum := sha256.Sum256([]byte(link))
+			entry.Hash = hex.EncodeToString(sum[:])
+		} else if info.Mode().IsRegular() {
+			entry.Hash, entry.Binary, err = hashFile(path)
+			if err != nil {
+				continue
+			}
+		} else {
+			continue
+		}
+		manifest[name] = entry
+	}
+	return manifest, nil
+}
+
+func hashFile(path string) (string, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	// Sniff the head of the file for NUL bytes, the way git detects binaries
+	head := make([]byte, 8000)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	h := sha256.New()
+	h.Write(head)
+	if _, err := io.Copy(h, f); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), bytes.IndexByte(head, 0) >= 0, nil
+}
+
+func saveManifest(path string, manifest Manifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func loadManifest(path string) (Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	return manifest, json.Unmarshal(data, &manifest)
+}
+
+func diffManifests(before, after Manifest) ChangeReport {
+	var report ChangeReport
+	for name, entry := range after {
+		old, ok := before[name]
+		change := FileChange{Path: name, Size: entry.Size, Binary: entry.Binary}
+		switch {
+		case !ok:
+			report.Added = append(report.Added, change)
+		case old.Hash != entry.Hash || old.Mode != entry.Mode:
+			if old.Mode != entry.Mode {
+				change.OldMode, change.NewMode = old.Mode, entry.Mode
+			}
+			report.Modified = append(report.Modified, change)
+		}
+	}
+	for name, entry := range before {
+		if _, ok := after[name]; !ok {
+			report.Deleted = append(report.Deleted, FileChange{Path: name, Size: entry.Size, Binary: entry.Binary})
+		}
+	}
+
+	for _, list := range [][]FileChange{report.Added, report.Modified, report.Deleted} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+	return report
+}
+
+func (r ChangeReport) touched() int {
+	return len(r.Added) + len(r.Modified) + len(r.Deleted)
+}
+
+// summary renders the one-line overview printed after every session.
+func (r ChangeReport) summary() string {
+	binaries := 0
+	for _, change := range r.Added {
+		if change.Binary {
+			binaries++
+		}
+	}
+	added := fmt.Sprintf("%d added", len(r.Added))
+	if binaries > 0 {
+		added += fmt.Sprintf(" (%d binary)", binaries)
+	}
+	return fmt.Sprintf("%d files touched: %s, %d modified, %d deleted",
+		r.touched(), added, len(r.Modified), len(r.Deleted))
+}
+
+func (r ChangeReport) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session %s (%s)\n", r.Session, r.Project)
+	fmt.Fprintf(&b, "%s\n", r.summary())
+	if r.touched() > 0 {
+		b.WriteString("\n")
+	}
+	for _, change := range r.Added {
+		fmt.Fprintf(&b, "A  %s (%s%s)\n", change.Path, formatSize(change.Size), binaryNote(change))
+	}
+	for _, change := range r.Modified {
+		if change.OldMode != change.NewMode {
+			fmt.Fprintf(&b, "M  %s (mode %s → %s)\n", change.Path, change.OldMode, change.NewMode)
+		} else {
+			fmt.Fprintf(&b, "M  %s\n", change.Path)
+		}
+	}
+	for _, change := range r.Deleted {
+		fmt.Fprintf(&b, "D  %s\n", change.Path)
+	}
+	return b.String()
+}
+
+func binaryNote(change FileChange) string {
+	if change.Binary {
+		return ", binary"
+	}
+	return ""
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// recordManifest captures the pre-session manifest of the project mount.
+func recordManifest(session *Session) {
+	manifest, err := buildManifest(session.Path, nil)
+	if err != nil {
+		fmt.Printf("\033[33m⚠\033[0m  Failed to record file manifest: %v\n", err)
+		return
+	}
+	if err := saveManifest(filepath.Join(session.Dir(), "manifest-before.json"), manifest); err != nil {
+		fmt.Printf("\033[33m⚠\033[0m  Failed to save file manifest: %v\n", err)
+	}
+}
+
+// writeChangeReport diffs the project against the pre-session manifest and
+// stores the report as text and JSON in the session directory.
+func writeChangeReport(session *Session) (*ChangeReport, error) {
+	before, err := loadManifest(filepath.Join(session.Dir(), "manifest-before.json"))
+	if err != nil {
+		return nil, fmt.Errorf("no pre-session manifest: %w", err)
+	}
+	after, err := buildManifest(session.Path, before)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveManifest(filepath.Join(session.Dir(), "manifest-after.json"), after); err != nil {
+		return nil, err
+	}
+
+	report := diffManifests(before, after)
+	report.Session = session.ID
+	report.Project = session.Project
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	jsonPath := filepath.Join(session.Dir(), "changes.json")
+	if err := ioutil.WriteFile(jsonPath, data, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(session.Dir(), "changes.txt"), []byte(report.text()), 0600); err != nil {
+		return nil, err
+	}
+	session.ChangeReport = jsonPath
+	return &report, nil
+}
+
+func showChanges(args []string) {
+	asJSON := false
+	id := ""
+	for _, arg := range args {
+		if arg == "--json" {
+			asJSON = true
+		} else {
+			id = arg
+		}
+	}
+
+	var session *Session
+	var err error
+	if id != "" {
+		session, err = loadSession(id)
+	} else {
+		cwd, _ := os.Getwd()
+		session, err = latestSession(cwd)
+	}
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+
+	name := "changes.txt"
+	if asJSON {
+		name = "changes.json"
+	}
+	data, err := ioutil.ReadFile(filepath.Join(session.Dir(), name))
+	if err != nil {
+		log.Fatalf("\033[31m✗\033[0m No change report for session %s", session.ID)
+	}
+	os.Stdout.Write(data)
+	if asJSON {
+		fmt.Println()
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffManifests(t *testing.T) {
+	file := func(hash string, size int64) FileEntry {
+		return FileEntry{Hash: hash, Mode: 0644, Size: size}
+	}
+	paths := func(changes []FileChange) []string {
+		var names []string
+		for _, change := range changes {
+			names = append(names, change.Path)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name                     string
+		before, after            Manifest
+		added, modified, deleted []string
+	}{
+		{
+			name:   "unchanged",
+			before: Manifest{"a": file("1", 1)},
+			after:  Manifest{"a": file("1", 1)},
+		},
+		{
+			name:   "added, sorted",
+			before: Manifest{},
+			after:  Manifest{"b": file("2", 1), "a": file("1", 1)},
+			added:  []string{"a", "b"},
+		},
+		{
+			name:     "content changed",
+			before:   Manifest{"a": file("1", 1)},
+			after:    Manifest{"a": file("2", 1)},
+			modified: []string{"a"},
+		},
+		{
+			name:     "mode changed",
+			before:   Manifest{"run.sh": file("1", 1)},
+			after:    Manifest{"run.sh": {Hash: "1", Mode: 0755, Size: 1}},
+			modified: []string{"run.sh"},
+		},
+		{
+			name:    "deleted",
+			before:  Manifest{"a": file("1", 1), "b": file("2", 1)},
+			after:   Manifest{"a": file("1", 1)},
+			deleted: []string{"b"},
+		},
+		{
+			name:     "everything at once",
+			before:   Manifest{"keep": file("1", 1), "edit": file("2", 1), "gone": file("3", 1)},
+			after:    Manifest{"keep": file("1", 1), "edit": file("4", 2), "new": file("5", 1)},
+			added:    []string{"new"},
+			modified: []string{"edit"},
+			deleted:  []string{"gone"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := diffManifests(tt.before, tt.after)
+			if got := paths(report.Added); !reflect.DeepEqual(got, tt.added) {
+				t.Errorf("added %v, want %v", got, tt.added)
+			}
+			if got := paths(report.Modified); !reflect.DeepEqual(got, tt.modified) {
+				t.Errorf("modified %v, want %v", got, tt.modified)
+			}
+			if got := paths(report.Deleted); !reflect.DeepEqual(got, tt.deleted) {
+				t.Errorf("deleted %v, want %v", got, tt.deleted)
+			}
+			if want := len(tt.added) + len(tt.modified) + len(tt.deleted); report.touched() != want {
+				t.Errorf("touched %d, want %d", report.touched(), want)
+			}
+		})
+	}
+}
+
+func TestDiffManifestsModeChange(t *testing.T) {
+	before := Manifest{"run.sh": {Hash: "1", Mode: 0644}}
+	after := Manifest{"run.sh": {Hash: "1", Mode: 0755}}
+	report := diffManifests(before, after)
+	if len(report.Modified) != 1 || report.Modified[0].OldMode != 0644 || report.Modified[0].NewMode != 0755 {
+		t.Fatalf("modified %+v, want run.sh 0644 → 0755", report.Modified)
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".gitignore":     "*.log\nbuild/\n",
+		"main.go":        "package main\n",
+		"debug.log":      "noise\n",
+		"build/out":      "artifact\n",
+		"assets/img.png": "\x89PNG\x00\x00",
+	})
+
+	first, err := buildManifest(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{".gitignore", "main.go", "assets/img.png"} {
+		if _, ok := first[want]; !ok {
+			t.Errorf("manifest is missing %s", want)
+		}
+	}
+	for _, ignored := range []string{"debug.log", "build/out"} {
+		if _, ok := first[ignored]; ok {
+			t.Errorf("manifest has ignored %s", ignored)
+		}
+	}
+	if !first["assets/img.png"].Binary || first["main.go"].Binary {
+		t.Errorf("binary detection: img.png %v, main.go %v", first["assets/img.png"].Binary, first["main.go"].Binary)
+	}
+
+	// An edit shows up even when the previous manifest is reused
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second, err := buildManifest(dir, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := diffManifests(first, second)
+	if len(report.Modified) != 1 || report.Modified[0].Path != "main.go" || report.touched() != 1 {
+		t.Fatalf("report %+v, want only main.go modified", report)
+	}
+}
+
+func TestGitignoreMatch(t *testing.T) {
+	patterns := []string{"*.log", "build/", "/secrets.txt", "docs/*.pdf", "node_modules"}
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"logs/app.log", false, true},
+		{"build", true, true},
+		{"build", false, false},
+		{"web/build", true, true},
+		{"secrets.txt", false, true},
+		{"config/secrets.txt", false, false},
+		{"docs/manual.pdf", false, true},
+		{"other/docs/manual.pdf", false, false},
+		{"node_modules", true, true},
+		{"web/node_modules", true, true},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := gitignoreMatch(patterns, tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("gitignoreMatch(%q, dir=%v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{5 * 1024 * 1024, "5.0 MB"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.size); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
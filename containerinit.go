@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// initCommand wraps the container command with the in-container init helper
+// when a session needs something running next to it inside the container.
+func initCommand(config *Config, globalConfig *GlobalConfig, command []string) []string {
+	var flags []string
+	if resolveNetworkPolicy(config, globalConfig).Mode == "allowlist" {
+		flags = append(flags, "--egress")
+	}
+	if auditEnabled(config, globalConfig) {
+		flags = append(flags, "--audit")
+	}
+	if resolveForwardPolicy(config, globalConfig).enabled() {
+		flags = append(flags, "--forward")
+	}
+	if probes, _ := resolveReadyChecks(config); len(probes) > 0 {
+		flags = append(flags, "--ready")
+	}
+	if config.Hooks.container() {
+		flags = append(flags, "--hooks")
+	}
+	if len(flags) == 0 {
+		return command
+	}
+
+	wrapped := append([]string{containerToolPath, "_init"}, flags...)
+	wrapped = append(wrapped, "--")
+	return append(wrapped, command...)
+}
+
+// containerInit runs inside the container as "viber00t _init [flags] -- cmd",
+// starts the requested helpers and then runs cmd as a child until it exits.
+func containerInit(args []string) {
+	egress, audit, forward, ready, hooks := false, false, false, false, false
+	for len(args) > 0 && args[0] != "--" {
+		switch args[0] {
+		case "--egress":
+			egress = true
+		case "--audit":
+			audit = true
+		case "--forward":
+			forward = true
+		case "--ready":
+			ready = true
+		case "--hooks":
+			hooks = true
+		default:
+			fmt.Fprintf(os.Stderr, "viber00t: unknown init flag %s\n", args[0])
+			os.Exit(2)
+		}
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "viber00t: _init needs a command")
+		os.Exit(2)
+	}
+
+	if egress {
+		if err := egressRelay(containerEgressSock); err != nil {
+			fmt.Fprintf(os.Stderr, "viber00t: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if forward {
+		// Without the relay ports just aren't forwarded, not worth failing over
+		if err := forwardRelay(containerForwardDir); err != nil {
+			fmt.Fprintf(os.Stderr, "viber00t: %v\n", err)
+		}
+	}
+
+	if hooks && !runContainerHooks(os.Getenv(containerHooksEnv), "on_create", "on_start") {
+		os.Exit(1)
+	}
+
+	// Hold the agent back until the environment is usable
+	if ready && !waitReady(os.Getenv(containerReadyEnv)) {
+		os.Exit(1)
+	}
+
+	if hooks && !runContainerHooks(os.Getenv(containerHooksEnv), "on_attach") {
+		os.Exit(1)
+	}
+
+	var cmd *exec.Cmd
+	drain := func() {}
+	if audit {
+		if traced, wait := tracedCommand(args); traced != nil {
+			cmd, drain = traced, wait
+		}
+	}
+	if cmd == nil {
+		cmd = exec.Command(args[0], args[1:]...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The terminal delivers ^C to the child directly, everything else is passed on
+	signal.Ignore(syscall.SIGINT, syscall.SIGQUIT)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "viber00t: %v\n", err)
+		os.Exit(127)
+	}
+	// Only the child keeps the write ends of helper pipes
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	code := exitCode(cmd.Wait())
+	drain()
+	os.Exit(code)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CredentialPolicy controls the git credential bridge.
+type CredentialPolicy struct {
+	Bridge *bool    // answer git credential requests from the host (default true)
+	Hosts  []string // hosts the container may get credentials for, globs allowed
+}
+
+const (
+	containerToolPath       = "/opt/viber00t/bin/viber00t"
+	containerCredentialSock = "/run/viber00t/git-credential.sock"
+)
+
+// resolveCredentialPolicy merges the project [credentials] section over the global one.
+func resolveCredentialPolicy(config *Config, globalConfig *GlobalConfig) CredentialPolicy {
+	policy := globalConfig.Credentials
+	if config.Credentials.Bridge != nil {
+		policy.Bridge = config.Credentials.Bridge
+	}
+	if config.Credentials.Hosts != nil {
+		policy.Hosts = config.Credentials.Hosts
+	}
+	return policy
+}
+
+func (p CredentialPolicy) enabled() bool {
+	return p.Bridge == nil || *p.Bridge
+}
+
+func (p CredentialPolicy) allows(host string) bool {
+	for _, pattern := range p.Hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// toolMount exposes the running viber00t binary inside the container, where it
+// acts as the helper end of the host bridges.
+func toolMount() Mount {
+	self, err := os.Executable()
+	if err == nil {
+		self, err = filepath.EvalSymlinks(self)
+	}
+	if err != nil {
+		return Mount{Name: "viber00t", Mode: "off", Reason: "viber00t binary not found"}
+	}
+	return Mount{
+		Name:   "viber00t",
+		Source: self,
+		Target: containerToolPath,
+		Mode:   "ro",
+		Reason: "in-container helpers",
+	}
+}
+
+// credentialMounts describes the broker socket. sessionDir is empty when only describing.
+func credentialMounts(policy CredentialPolicy, sessionDir string) []Mount {
+	if !policy.enabled() {
+		return nil
+	}
+	if sessionDir == "" {
+		sessionDir = "<session>"
+	}
+	reason := "git credential bridge, no hosts allowed"
+	if len(policy.Hosts) > 0 {
+		reason = "git credential bridge for " + strings.Join(policy.Hosts, ", ")
+	}
+	return []Mount{{
+		Name:   "git_credential_bridge",
+		Source: filepath.Join(sessionDir, "git-credential.sock"),
+		Target: containerCredentialSock,
+		Mode:   "rw",
+		Reason: reason,
+	}}
+}
+
+// gitConfigEnv turns key/value pairs into GIT_CONFIG_* variables, which git
+// applies on top of every config file without touching them.
+func gitConfigEnv(entries [][2]string) []string {
+	if len(entries) == 0 {
+		return nil
+	}
+	env := []string{fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(entries))}
+	for i, entry := range entries {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, entry[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, entry[1]))
+	}
+	return env
+}
+
+// credentialGitConfig replaces any helpers from the mounted gitconfig with the bridge.
+func credentialGitConfig() [][2]string {
+	return [][2]string{
+		{"credential.helper", ""},
+		{"credential.helper", containerToolPath + " _git-credential"},
+	}
+}
+
+// credentialBroker answers git credential requests from the container using
+// the host's own credential helpers.
+type credentialBroker struct {
+	policy  CredentialPolicy
+	logPath string
+}
+
+func startCredentialBroker(policy CredentialPolicy, session *Session) error {
+	sock := filepath.Join(session.Dir(), "git-credential.sock")
+	os.Remove(sock)
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return fmt.Errorf("failed to start git credential bridge: %w", err)
+	}
+	os.Chmod(sock, 0600)
+	session.onFinish(func() {
+		listener.Close()
+		os.Remove(sock)
+	})
+
+	broker := &credentialBroker{policy: policy, logPath: filepath.Join(session.Dir(), "git-credentials.log")}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// serve handles one request: the operation on the first line followed by the
+// attributes of the git credential protocol.
+func (b *credentialBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	reader := bufio.NewReader(conn)
+	op, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	op = strings.TrimSpace(op)
+
+	attrs := map[string]string{}
+	var rejected []string
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			if credentialKeys[key] {
+				attrs[key] = value
+			} else {
+				rejected = append(rejected, key)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	request, target := credentialRequest(attrs)
+	if op != "get" {
+		// The container never gets to store or erase host credentials
+		b.log(op, target, "ignored")
+		return
+	}
+	for _, key := range rejected {
+		if key == "url" {
+			// url= would override protocol and host after the allowlist check
+			b.log(op, target, "denied (url)")
+			return
+		}
+	}
+	if !b.policy.allows(attrs["host"]) {
+		b.log(op, target, "denied")
+		return
+	}
+
+	cmd := exec.Command("git", "credential", "fill")
+	// Away from the project, so its .git/config can't name a helper for us to run
+	cmd.Dir = os.TempDir()
+	cmd.Stdin = strings.NewReader(request)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.Output()
+	if err != nil {
+		b.log(op, target, "no credentials on host")
+		return
+	}
+	b.log(op, target, "allowed")
+	conn.Write(out)
+}
+
+// credentialKeys are the attributes passed on to the host's git; anything
+// else the container sends is dropped.
+var credentialKeys = map[string]bool{"protocol": true, "host": true, "path": true, "username": true}
+
+// credentialRequest rebuilds the request for the host's git from the checked
+// attributes only, and the target it asks for.
+func credentialRequest(attrs map[string]string) (request, target string) {
+	var b strings.Builder
+	for _, key := range []string{"protocol", "host", "path", "username"} {
+		if value, ok := attrs[key]; ok {
+			fmt.Fprintf(&b, "%s=%s\n", key, value)
+		}
+	}
+	target = attrs["protocol"] + "://" + attrs["host"]
+	if attrs["path"] != "" {
+		target += "/" + attrs["path"]
+	}
+	return b.String(), target
+}
+
+func (b *credentialBroker) log(op, target, verdict string) {
+	f, err := os.OpenFile(b.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s %s %s: %s\n", time.Now().Format(time.RFC3339), op, target, verdict)
+}
+
+// gitCredentialHelper is the container end of the bridge, run by git as
+// "viber00t _git-credential <op>".
+func gitCredentialHelper(args []string) {
+	if len(args) == 0 {
+		os.Exit(1)
+	}
+	sock := os.Getenv("VIBER00T_CREDENTIAL_SOCK")
+	if sock == "" {
+		sock = containerCredentialSock
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "viber00t: git credential bridge unavailable: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "%s\n", args[0])
+	io.Copy(conn, os.Stdin)
+	conn.Write([]byte("\n"))
+	io.Copy(os.Stdout, conn)
+}
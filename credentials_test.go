@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialPolicyAllows(t *testing.T) {
+	policy := CredentialPolicy{Hosts: []string{"github.com", "*.example.com"}}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"github.com", true},
+		{"gitlab.com", false},
+		{"git.example.com", true},
+		{"example.com", false},
+		{"evil.com/.example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := policy.allows(tt.host); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+	if (CredentialPolicy{}).allows("github.com") {
+		t.Errorf("an empty allowlist allows github.com")
+	}
+}
+
+func TestCredentialRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		attrs   map[string]string
+		request string
+		target  string
+	}{
+		{
+			name:    "host only",
+			attrs:   map[string]string{"protocol": "https", "host": "github.com"},
+			request: "protocol=https\nhost=github.com\n",
+			target:  "https://github.com",
+		},
+		{
+			name:    "fixed key order",
+			attrs:   map[string]string{"username": "me", "path": "org/repo.git", "host": "github.com", "protocol": "https"},
+			request: "protocol=https\nhost=github.com\npath=org/repo.git\nusername=me\n",
+			target:  "https://github.com/org/repo.git",
+		},
+		{
+			name:    "other keys are dropped",
+			attrs:   map[string]string{"protocol": "https", "host": "github.com", "url": "https://evil.com", "password": "x"},
+			request: "protocol=https\nhost=github.com\n",
+			target:  "https://github.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, target := credentialRequest(tt.attrs)
+			if request != tt.request {
+				t.Errorf("request %q, want %q", request, tt.request)
+			}
+			if target != tt.target {
+				t.Errorf("target %q, want %q", target, tt.target)
+			}
+		})
+	}
+}
+
+func TestCredentialBrokerServe(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	// A host git whose only credential helper answers with a fixed password
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "credential.helper")
+	t.Setenv("GIT_CONFIG_VALUE_0", "!f() { echo username=me; echo password=hunter2; }; f")
+
+	tests := []struct {
+		name    string
+		input   string
+		granted bool
+		verdict string
+	}{
+		{"allowed host", "get\nprotocol=https\nhost=github.com\n\n", true, "get https://github.com: allowed"},
+		{"other host", "get\nprotocol=https\nhost=gitlab.com\n\n", false, "get https://gitlab.com: denied"},
+		{"url overrides the host", "get\nprotocol=https\nhost=github.com\nurl=https://gitlab.com\n\n", false, "get https://github.com: denied (url)"},
+		{"store", "store\nprotocol=https\nhost=github.com\nusername=me\npassword=x\n\n", false, "store https://github.com: ignored"},
+		{"erase", "erase\nprotocol=https\nhost=github.com\n\n", false, "erase https://github.com: ignored"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logPath := filepath.Join(t.TempDir(), "credentials.log")
+			broker := &credentialBroker{policy: CredentialPolicy{Hosts: []string{"github.com"}}, logPath: logPath}
+
+			client, server := net.Pipe()
+			go broker.serve(server)
+			go func() {
+				client.Write([]byte(tt.input))
+			}()
+			reply, _ := ioutil.ReadAll(client)
+			client.Close()
+
+			if granted := strings.Contains(string(reply), "password=hunter2"); granted != tt.granted {
+				t.Errorf("granted = %v, want %v (reply %q)", granted, tt.granted, reply)
+			}
+			data, _ := ioutil.ReadFile(logPath)
+			if !strings.Contains(string(data), tt.verdict) {
+				t.Errorf("log %q, want %q", data, tt.verdict)
+			}
+		})
+	}
+
+	t.Run("hostile repo config", func(t *testing.T) {
+		// The project is the cwd and its config plants a helper of its own
+		repo := t.TempDir()
+		marker := filepath.Join(t.TempDir(), "helper-ran")
+		for _, args := range [][]string{
+			{"init", "-q", repo},
+			{"-C", repo, "config", "credential.helper", "!touch " + marker},
+		} {
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				t.Fatalf("git %v: %v\n%s", args, err, out)
+			}
+		}
+		t.Chdir(repo)
+
+		broker := &credentialBroker{policy: CredentialPolicy{Hosts: []string{"github.com"}}, logPath: filepath.Join(t.TempDir(), "credentials.log")}
+		client, server := net.Pipe()
+		go broker.serve(server)
+		go func() {
+			client.Write([]byte("get\nprotocol=https\nhost=github.com\n\n"))
+		}()
+		reply, _ := ioutil.ReadAll(client)
+		client.Close()
+
+		if !strings.Contains(string(reply), "password=hunter2") {
+			t.Errorf("reply %q, want the host's credentials", reply)
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Error("the project's credential helper ran")
+		}
+	})
+}
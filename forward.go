@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ForwardPolicy is the [forward] section: ports that services in the
+// container start listening on are forwarded to localhost on the host.
+type ForwardPolicy struct {
+	Enabled *bool      // default true
+	Allow   []portSpec // only forward these ports (default: all)
+	Deny    []portSpec // never forward these
+}
+
+const containerForwardDir = "/run/viber00t/forward"
+
+// resolveForwardPolicy layers the project [forward] section over the global one.
+func resolveForwardPolicy(config *Config, globalConfig *GlobalConfig) ForwardPolicy {
+	policy := globalConfig.Forward
+	if config.Forward.Enabled != nil {
+		policy.Enabled = config.Forward.Enabled
+	}
+	policy.Allow = append(append([]portSpec(nil), policy.Allow...), config.Forward.Allow...)
+	policy.Deny = append(append([]portSpec(nil), policy.Deny...), config.Forward.Deny...)
+
+	// Like [[ports]], nothing reaches the host's localhost without host networking
+	if resolveNetworkPolicy(config, globalConfig).Mode != "full" {
+		off := false
+		policy.Enabled = &off
+	}
+	return policy
+}
+
+func (p ForwardPolicy) enabled() bool {
+	return p.Enabled == nil || *p.Enabled
+}
+
+func (p ForwardPolicy) allows(port int) bool {
+	for _, spec := range p.Deny {
+		if port >= spec.Start && port <= spec.End {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, spec := range p.Allow {
+		if port >= spec.Start && port <= spec.End {
+			return true
+		}
+	}
+	return false
+}
+
+// printable drops control characters (terminal escapes) and caps the length.
+func printable(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	if runes := []rune(s); len(runes) > max {
+		s = string(runes[:max])
+	}
+	return s
+}
+
+// forwardMounts describes the relay directory. sessionDir is empty when only describing.
+func forwardMounts(policy ForwardPolicy, sessionDir string) []Mount {
+	if !policy.enabled() {
+		return nil
+	}
+	if sessionDir == "" {
+		sessionDir = "<session>"
+	}
+	return []Mount{{
+		Name:   "port_forward",
+		Source: filepath.Join(sessionDir, "forward"),
+		Target: containerForwardDir,
+		Mode:   "rw",
+		Reason: "automatic port forwarding",
+	}}
+}
+
+// portForwarder is the host side: it asks the in-container relay which ports
+// are listening and opens a localhost listener for each one it may forward.
+type portForwarder struct {
+	policy    ForwardPolicy
+	sock      string
+	logPath   string
+	published map[int]bool // already published through [[ports]]
+	routes    *sessionRoutes
+
+	mu       sync.Mutex
+	forwards map[int]net.Listener // nil for ports that are not forwarded
+	done     chan struct{}
+}
+
+// startForwarder creates the relay directory and polls the container for new
+// listening ports until the session ends.
+func startForwarder(policy ForwardPolicy, published []portBinding, routes *sessionRoutes, session *Session) error {
+	dir := filepath.Join(session.Dir(), "forward")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to set up port forwarding: %w", err)
+	}
+
+	f := &portForwarder{
+		policy:    policy,
+		sock:      filepath.Join(dir, "relay.sock"),
+		logPath:   filepath.Join(session.Dir(), "forward.log"),
+		published: make(map[int]bool),
+		routes:    routes,
+		forwards:  make(map[int]net.Listener),
+		done:      make(chan struct{}),
+	}
+	for _, port := range published {
+		for p := port.Container.Start; p <= port.Container.End; p++ {
+			f.published[p] = true
+		}
+	}
+	session.onFinish(f.stop)
+	go f.run()
+	return nil
+}
+
+func (f *portForwarder) run() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.sync()
+		}
+	}
+}
+
+func (f *portForwarder) stop() {
+	close(f.done)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for port, listener := range f.forwards {
+		if listener != nil {
+			listener.Close()
+		}
+		delete(f.forwards, port)
+	}
+}
+
+// sync opens forwards for new listening ports and closes those that went away.
+func (f *portForwarder) sync() {
+	listening, err := f.list()
+	if err != nil {
+		return // container not up yet, or gone
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.done:
+		return
+	default:
+	}
+
+	for port, name := range listening {
+		if _, known := f.forwards[port]; known {
+			continue
+		}
+		if f.published[port] || !f.policy.allows(port) {
+			f.forwards[port] = nil
+			f.log("%s on :%d not forwarded", name, port)
+			continue
+		}
+		f.forwards[port] = f.open(port, name)
+	}
+	for port, listener := range f.forwards {
+		if _, ok := listening[port]; ok {
+			continue
+		}
+		if listener != nil {
+			listener.Close()
+			f.routes.remove(listener.Addr().String())
+			f.log(":%d closed", port)
+		}
+		delete(f.forwards, port)
+	}
+}
+
+// list asks the relay for the container's listening ports and their programs.
+func (f *portForwarder) list() (map[int]string, error) {
+	conn, err := net.DialTimeout("unix", f.sock, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintln(conn, "LIST")
+
+	listening := make(map[int]string)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		portField, name, _ := strings.Cut(scanner.Text(), " ")
+		if port, err := strconv.Atoi(portField); err == nil {
+			// The name comes from the container and ends up on the host terminal
+			listening[port] = printable(name, 32)
+		}
+	}
+	return listening, scanner.Err()
+}
+
+// open listens on the same port on the host when it's free, any free port otherwise.
+func (f *portForwarder) open(port int, name string) net.Listener {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		if listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			f.log("%s on :%d: %v", name, port, err)
+			return nil
+		}
+	}
+	hostPort := listener.Addr().(*net.TCPAddr).Port
+	url := f.routes.add(name, listener.Addr().String())
+	if numbered := f.routes.add(strconv.Itoa(port), listener.Addr().String()); url == "" {
+		url = numbered
+	}
+	if url != "" {
+		url = " \033[90m" + url + "\033[0m"
+	}
+	fmt.Fprintf(os.Stderr, "\r\n\033[35m◉\033[0m %s on :%d → localhost:%d%s\r\n", name, port, hostPort, url)
+	f.log("%s on :%d forwarded to localhost:%d", name, port, hostPort)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn, port)
+		}
+	}()
+	return listener
+}
+
+func (f *portForwarder) serve(conn net.Conn, port int) {
+	defer conn.Close()
+	upstream, err := net.Dial("unix", f.sock)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	fmt.Fprintf(upstream, "CONNECT %d\n", port)
+	reader := bufio.NewReader(upstream)
+	status, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(status) != "OK" {
+		f.log(":%d: %s", port, strings.TrimSpace(strings.TrimPrefix(status, "ERR")))
+		return
+	}
+
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.(*net.UnixConn).CloseWrite()
+	}()
+	io.Copy(conn, reader)
+}
+
+func (f *portForwarder) log(format string, args ...interface{}) {
+	file, err := os.OpenFile(f.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintf(file, "%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+// forwardRelay is the container end: it reports listening ports and connects
+// the host's forwards to them over a socket in the shared relay directory.
+func forwardRelay(dir string) error {
+	sock := filepath.Join(dir, "relay.sock")
+	os.Remove(sock)
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return fmt.Errorf("port forward relay: %w", err)
+	}
+	os.Chmod(sock, 0600)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveForwardRelay(conn)
+		}
+	}()
+	return nil
+}
+
+func serveForwardRelay(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	command, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+	switch command {
+	case "LIST":
+		sockets := listeningSockets()
+		ports := make([]int, 0, len(sockets))
+		for port := range sockets {
+			ports = append(ports, port)
+		}
+		sort.Ints(ports)
+		for _, port := range ports {
+			fmt.Fprintf(conn, "%d %s\n", port, sockets[port].name)
+		}
+	case "CONNECT":
+		port, _ := strconv.Atoi(arg)
+		socket, ok := listeningSockets()[port]
+		if !ok {
+			fmt.Fprintf(conn, "ERR nothing listening on %d\n", port)
+			return
+		}
+		upstream, err := net.Dial("tcp", net.JoinHostPort(socket.dialIP().String(), arg))
+		if err != nil {
+			fmt.Fprintf(conn, "ERR %v\n", err)
+			return
+		}
+		defer upstream.Close()
+		fmt.Fprintln(conn, "OK")
+		go func() {
+			io.Copy(upstream, reader)
+			upstream.(*net.TCPConn).CloseWrite()
+		}()
+		io.Copy(conn, upstream)
+	}
+}
+
+// listeningSocket is a TCP listener found in /proc/net.
+type listeningSocket struct {
+	ip   net.IP
+	name string
+}
+
+// dialIP is where the relay connects: loopback for wildcard listeners.
+func (s listeningSocket) dialIP() net.IP {
+	switch {
+	case s.ip.Equal(net.IPv4zero):
+		return net.IPv4(127, 0, 0, 1)
+	case s.ip.Equal(net.IPv6unspecified):
+		return net.IPv6loopback
+	}
+	return s.ip
+}
+
+// listeningSockets reads the TCP listeners in the container's network
+// namespace, named after the program that owns them. The relay's own
+// listeners (the egress relay) are left out.
+func listeningSockets() map[int]listeningSocket {
+	owners := socketOwners()
+	self := os.Getpid()
+
+	sockets := make(map[int]listeningSocket)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := ioutil.ReadFile(table)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n")[1:] {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[3] != "0A" {
+				continue
+			}
+			hexIP, hexPort, _ := strings.Cut(fields[1], ":")
+			port, err := strconv.ParseInt(hexPort, 16, 32)
+			ip := parseProcIP(hexIP)
+			if err != nil || ip == nil {
+				continue
+			}
+			pid, owned := owners[fields[9]]
+			if pid == self {
+				continue
+			}
+			if _, seen := sockets[int(port)]; seen {
+				continue // the same port on IPv4 and IPv6
+			}
+			name := "port"
+			if owned {
+				name = processName(pid)
+			}
+			sockets[int(port)] = listeningSocket{ip: ip, name: name}
+		}
+	}
+	return sockets
+}
+
+// parseProcIP decodes an address from /proc/net/tcp, stored as 32-bit words
+// in host (little-endian) byte order.
+func parseProcIP(s string) net.IP {
+	raw, err := hex.DecodeString(s)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return nil
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return ip
+}
+
+// socketOwners maps socket inodes to the pid holding them open.
+func socketOwners() map[string]int {
+	owners := make(map[string]int)
+	procs, _ := ioutil.ReadDir("/proc")
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, _ := ioutil.ReadDir(fdDir)
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err == nil && strings.HasPrefix(target, "socket:[") {
+				owners[strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")] = pid
+			}
+		}
+	}
+	return owners
+}
+
+// Interpreters are named after the script they run: "node .../vite" is vite.
+var interpreters = map[string]bool{
+	"node": true, "bun": true, "deno": true, "python": true, "python3": true,
+	"ruby": true, "php": true, "java": true, "sh": true, "bash": true,
+}
+
+func processName(pid int) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || len(data) == 0 {
+		return "port"
+	}
+	argv := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	name := filepath.Base(argv[0])
+	if interpreters[name] {
+		for i, arg := range argv[1:] {
+			if arg == "-m" && i+2 < len(argv) {
+				return argv[i+2] // python -m http.server
+			}
+			if !strings.HasPrefix(arg, "-") {
+				return strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg))
+			}
+		}
+	}
+	return name
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestForwardPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ForwardPolicy
+		ports  map[int]bool
+	}{
+		{"everything", ForwardPolicy{}, map[int]bool{22: true, 3000: true}},
+		{
+			"allowlist",
+			ForwardPolicy{Allow: []portSpec{{Start: 3000, End: 3010}, {Start: 8080, End: 8080}}},
+			map[int]bool{3000: true, 3010: true, 3011: false, 8080: true, 22: false},
+		},
+		{
+			"deny wins",
+			ForwardPolicy{Allow: []portSpec{{Start: 3000, End: 4000}}, Deny: []portSpec{{Start: 3306, End: 3306}}},
+			map[int]bool{3000: true, 3306: false},
+		},
+		{"deny only", ForwardPolicy{Deny: []portSpec{{Start: 1, End: 1023}}}, map[int]bool{22: false, 5173: true}},
+	}
+	for _, tt := range tests {
+		for port, want := range tt.ports {
+			if got := tt.policy.allows(port); got != want {
+				t.Errorf("%s: allows(%d) = %v, want %v", tt.name, port, got, want)
+			}
+		}
+	}
+}
+
+func TestParseProcIP(t *testing.T) {
+	tests := []struct {
+		hex  string
+		want net.IP
+	}{
+		{"0100007F", net.IPv4(127, 0, 0, 1)},
+		{"00000000", net.IPv4zero},
+		{"0100A8C0", net.IPv4(192, 168, 0, 1)},
+		{"00000000000000000000000001000000", net.IPv6loopback},
+		{"00000000000000000000000000000000", net.IPv6unspecified},
+		{"7F", nil},
+		{"zz00007F", nil},
+	}
+	for _, tt := range tests {
+		got := parseProcIP(tt.hex)
+		if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(tt.want)) {
+			t.Errorf("parseProcIP(%q) = %v, want %v", tt.hex, got, tt.want)
+		}
+	}
+}
+
+func TestListeningSocketDialIP(t *testing.T) {
+	tests := []struct {
+		ip, want net.IP
+	}{
+		{net.IPv4zero, net.IPv4(127, 0, 0, 1)},
+		{net.IPv6unspecified, net.IPv6loopback},
+		{net.IPv4(10, 88, 0, 2), net.IPv4(10, 88, 0, 2)},
+	}
+	for _, tt := range tests {
+		if got := (listeningSocket{ip: tt.ip}).dialIP(); !got.Equal(tt.want) {
+			t.Errorf("dialIP for %v = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestListeningSocketsSkipsSelf(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	// The relay must not forward its own listeners
+	if socket, ok := listeningSockets()[port]; ok {
+		t.Errorf("own listener on %d listed as %q", port, socket.name)
+	}
+}
+
+func TestResolveForwardPolicy(t *testing.T) {
+	globalConfig := &GlobalConfig{}
+	globalConfig.Forward.Deny = []portSpec{{Start: 22, End: 22}}
+	config := decodeConfig(t, "[forward]\nallow = [3000]\n")
+	policy := resolveForwardPolicy(config, globalConfig)
+	if !policy.enabled() || len(policy.Allow) != 1 || len(policy.Deny) != 1 {
+		t.Errorf("policy %+v, want enabled with the global deny and project allow", policy)
+	}
+
+	// Like [[ports]], forwarding needs host networking
+	for _, mode := range []string{"none", "allowlist"} {
+		config.Network.Mode = mode
+		if resolveForwardPolicy(config, globalConfig).enabled() {
+			t.Errorf("forwarding enabled with network mode %s", mode)
+		}
+	}
+}
+
+func TestPrintable(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"vite", 32, "vite"},
+		{"evil\x1b]0;pwned\x07", 32, "evil]0;pwned"},
+		{"line\r\nbreak\ttab", 32, "linebreaktab"},
+		{"ünïcödé-server", 6, "ünïcöd"},
+		{"", 32, ""},
+	}
+	for _, tt := range tests {
+		if got := printable(tt.in, tt.max); got != tt.want {
+			t.Errorf("printable(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
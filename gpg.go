@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GPGPolicy controls gpg-agent forwarding for signed commits.
+type GPGPolicy struct {
+	Forward     *bool  // forward the host gpg-agent (opt-in)
+	SigningKey  string `toml:"signing_key"`  // defaults to the host's user.signingkey
+	SignCommits *bool  `toml:"sign_commits"` // set commit.gpgsign inside the container
+	Confirm     *bool  // ask on the host before every signature
+}
+
+// resolveGPGPolicy merges the project [gpg] section over the global one.
+func resolveGPGPolicy(config *Config, globalConfig *GlobalConfig) GPGPolicy {
+	policy := globalConfig.GPG
+	if config.GPG.Forward != nil {
+		policy.Forward = config.GPG.Forward
+	}
+	if config.GPG.SigningKey != "" {
+		policy.SigningKey = config.GPG.SigningKey
+	}
+	if config.GPG.SignCommits != nil {
+		policy.SignCommits = config.GPG.SignCommits
+	}
+	if config.GPG.Confirm != nil {
+		policy.Confirm = config.GPG.Confirm
+	}
+	return policy
+}
+
+func (p GPGPolicy) enabled() bool {
+	return p.Forward != nil && *p.Forward
+}
+
+// gpgMounts describes the session gnupg home. sessionDir is empty when only describing.
+func gpgMounts(policy GPGPolicy, sessionDir string) []Mount {
+	if !policy.enabled() {
+		return nil
+	}
+	if sessionDir == "" {
+		sessionDir = "<session>"
+	}
+	return []Mount{{
+		Name:   "gnupg",
+		Source: filepath.Join(sessionDir, "gnupg"),
+		Target: "/root/.gnupg",
+		Mode:   "rw",
+		Reason: "public keyring copy and forwarded gpg-agent",
+	}}
+}
+
+// setupGPG builds a gnupg home holding only the host's public keys, with the
+// host agent's restricted extra socket relayed into it.
+func setupGPG(policy GPGPolicy, session *Session) error {
+	if policy.Confirm != nil && *policy.Confirm && !askpassAvailable() {
+		return fmt.Errorf("[gpg] confirm = true needs SSH_ASKPASS and a display on the host")
+	}
+	out, err := exec.Command("gpgconf", "--list-dirs", "agent-extra-socket").Output()
+	if err != nil {
+		return fmt.Errorf("gpgconf not available on host: %w", err)
+	}
+	upstream := strings.TrimSpace(string(out))
+	if _, err := os.Stat(upstream); err != nil {
+		// The agent creates its sockets on first use
+		exec.Command("gpgconf", "--launch", "gpg-agent").Run()
+	}
+
+	home := filepath.Join(session.Dir(), "gnupg")
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return err
+	}
+
+	// Public keys and ownertrust only, secret keys stay with the host agent
+	pubkeys, err := exec.Command("gpg", "--export").Output()
+	if err != nil {
+		return fmt.Errorf("failed to export public keys: %w", err)
+	}
+	if err := gpgImport(home, "--import", pubkeys); err != nil {
+		return err
+	}
+	if trust, err := exec.Command("gpg", "--export-ownertrust").Output(); err == nil {
+		gpgImport(home, "--import-ownertrust", trust)
+	}
+	if err := ioutil.WriteFile(filepath.Join(home, "gpg.conf"), []byte("no-autostart\n"), 0600); err != nil {
+		return err
+	}
+
+	relay := &gpgAgentRelay{
+		upstream: upstream,
+		confirm:  policy.Confirm != nil && *policy.Confirm,
+		logPath:  filepath.Join(session.Dir(), "gpg-agent.log"),
+	}
+	return relay.listen(filepath.Join(home, "S.gpg-agent"), session)
+}
+
+// gpgGitConfig returns the git settings needed for signing.
+func gpgGitConfig(policy GPGPolicy) [][2]string {
+	key := policy.SigningKey
+	if key == "" {
+		if out, err := exec.Command("git", "config", "--global", "user.signingkey").Output(); err == nil {
+			key = strings.TrimSpace(string(out))
+		}
+	}
+	gitConfig := [][2]string{{"gpg.program", "gpg"}}
+	if key != "" {
+		gitConfig = append(gitConfig, [2]string{"user.signingkey", key})
+	}
+	if policy.SignCommits != nil && *policy.SignCommits {
+		gitConfig = append(gitConfig, [2]string{"commit.gpgsign", "true"})
+	}
+	return gitConfig
+}
+
+func gpgImport(home, op string, data []byte) error {
+	cmd := exec.Command("gpg", "--homedir", home, "--batch", "--quiet", op)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("gpg %s failed: %s", op, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// gpgAgentRelay passes the Assuan protocol through to the host agent's extra
+// socket, logging signing operations and optionally asking for confirmation.
+type gpgAgentRelay struct {
+	upstream string
+	confirm  bool
+	logPath  string
+}
+
+func (r *gpgAgentRelay) listen(path string, session *Session) error {
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to start gpg-agent relay: %w", err)
+	}
+	os.Chmod(path, 0600)
+	session.onFinish(func() {
+		listener.Close()
+		os.Remove(path)
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return nil
+}
+
+func (r *gpgAgentRelay) serve(conn net.Conn) {
+	defer conn.Close()
+
+	upstream, err := dialGPGAgent(r.upstream)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR 67109115 No agent running <viber00t>\n")
+		return
+	}
+	defer upstream.Close()
+
+	// Replies come from the agent and from the relay's own denials
+	out := &lockedWriter{w: conn}
+	go io.Copy(out, upstream)
+
+	keygrip := ""
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch command {
+		case "SIGKEY", "SETKEY":
+			keygrip = strings.TrimSpace(line[len(command):])
+		case "PKSIGN":
+			// The agent is idle waiting for this command, so answering here is safe
+			if r.confirm && !askpassConfirm(fmt.Sprintf("viber00t: allow the container to gpg-sign with key %s?", keygrip)) {
+				r.log(keygrip, "denied (not confirmed)")
+				fmt.Fprintf(out, "ERR 83886179 Operation cancelled <viber00t>\n")
+				continue
+			}
+			r.log(keygrip, "allowed")
+		}
+
+		if _, err := upstream.Write([]byte(line)); err != nil {
+			return
+		}
+	}
+}
+
+// lockedWriter serializes writes to a connection shared by several goroutines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// dialGPGAgent connects to an agent socket, following the redirect files
+// gpg uses when sockets live on file systems without unix socket support.
+func dialGPGAgent(path string) (net.Conn, error) {
+	if data, err := ioutil.ReadFile(path); err == nil && bytes.HasPrefix(data, []byte("%Assuan%")) {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "socket=") {
+				path = strings.TrimPrefix(line, "socket=")
+			}
+		}
+	}
+	return net.Dial("unix", path)
+}
+
+func (r *gpgAgentRelay) log(keygrip, verdict string) {
+	f, err := os.OpenFile(r.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s sign %s: %s\n", time.Now().Format(time.RFC3339), keygrip, verdict)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeGPGAgent speaks just enough Assuan to sign: it greets, acknowledges
+// every command and answers PKSIGN with a signature. It returns the commands
+// it received once the connection is closed.
+func fakeGPGAgent(conn net.Conn) []string {
+	defer conn.Close()
+	conn.Write([]byte("OK Pleased to meet you\n"))
+	var commands []string
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return commands
+		}
+		line = strings.TrimSpace(line)
+		commands = append(commands, line)
+		if line == "PKSIGN" {
+			conn.Write([]byte("D (7:sig-val)\n"))
+		}
+		conn.Write([]byte("OK\n"))
+	}
+}
+
+// assuan sends a command and returns the reply lines up to OK or ERR.
+func assuan(t *testing.T, conn net.Conn, reader *bufio.Reader, command string) []string {
+	t.Helper()
+	if command != "" {
+		if _, err := conn.Write([]byte(command + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var reply []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+		reply = append(reply, strings.TrimSpace(line))
+		if strings.HasPrefix(line, "OK") || strings.HasPrefix(line, "ERR") {
+			return reply
+		}
+	}
+}
+
+func TestGPGAgentRelay(t *testing.T) {
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "S.gpg-agent.extra")
+	listener, err := net.Listen("unix", upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			received <- fakeGPGAgent(conn)
+		}
+	}()
+
+	logPath := filepath.Join(dir, "gpg-agent.log")
+	relay := &gpgAgentRelay{upstream: upstream, logPath: logPath}
+	client, server := net.Pipe()
+	go relay.serve(server)
+	reader := bufio.NewReader(client)
+
+	if reply := assuan(t, client, reader, ""); reply[0] != "OK Pleased to meet you" {
+		t.Fatalf("greeting %q", reply)
+	}
+	assuan(t, client, reader, "SIGKEY ABCDEF0123")
+	if reply := assuan(t, client, reader, "PKSIGN"); !reflect.DeepEqual(reply, []string{"D (7:sig-val)", "OK"}) {
+		t.Errorf("PKSIGN reply %q", reply)
+	}
+	client.Close()
+
+	if commands := <-received; !reflect.DeepEqual(commands, []string{"SIGKEY ABCDEF0123", "PKSIGN"}) {
+		t.Errorf("agent received %q", commands)
+	}
+	data, _ := ioutil.ReadFile(logPath)
+	if !strings.Contains(string(data), "sign ABCDEF0123: allowed") {
+		t.Errorf("log %q", data)
+	}
+}
+
+func TestGPGAgentRelayDenied(t *testing.T) {
+	dir := t.TempDir()
+	askpass := filepath.Join(dir, "askpass")
+	ioutil.WriteFile(askpass, []byte("#!/bin/sh\nexit 1\n"), 0755)
+	t.Setenv("SSH_ASKPASS", askpass)
+	t.Setenv("DISPLAY", ":0")
+
+	upstream := filepath.Join(dir, "S.gpg-agent.extra")
+	listener, err := net.Listen("unix", upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			received <- fakeGPGAgent(conn)
+		}
+	}()
+
+	logPath := filepath.Join(dir, "gpg-agent.log")
+	relay := &gpgAgentRelay{upstream: upstream, confirm: true, logPath: logPath}
+	client, server := net.Pipe()
+	go relay.serve(server)
+	reader := bufio.NewReader(client)
+
+	assuan(t, client, reader, "")
+	assuan(t, client, reader, "SIGKEY ABCDEF0123")
+	if reply := assuan(t, client, reader, "PKSIGN"); len(reply) != 1 || !strings.HasPrefix(reply[0], "ERR 83886179") {
+		t.Errorf("PKSIGN reply %q, want a cancellation", reply)
+	}
+	// The connection keeps working after a denial
+	if reply := assuan(t, client, reader, "NOP"); !reflect.DeepEqual(reply, []string{"OK"}) {
+		t.Errorf("NOP reply %q", reply)
+	}
+	client.Close()
+
+	if commands := <-received; !reflect.DeepEqual(commands, []string{"SIGKEY ABCDEF0123", "NOP"}) {
+		t.Errorf("agent received %q", commands)
+	}
+	data, _ := ioutil.ReadFile(logPath)
+	if !strings.Contains(string(data), "sign ABCDEF0123: denied (not confirmed)") {
+		t.Errorf("log %q", data)
+	}
+}
+
+func TestSetupGPGConfirmNeedsAskpass(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("SSH_ASKPASS", "")
+	yes := true
+	err := setupGPG(GPGPolicy{Forward: &yes, Confirm: &yes}, &Session{ID: "test-gpg-confirm"})
+	if err == nil || !strings.Contains(err.Error(), "SSH_ASKPASS") {
+		t.Errorf("err = %v, want a missing SSH_ASKPASS error", err)
+	}
+}
+
+func TestGPGAgentRelayNoAgent(t *testing.T) {
+	relay := &gpgAgentRelay{upstream: filepath.Join(t.TempDir(), "missing"), logPath: filepath.Join(t.TempDir(), "log")}
+	client, server := net.Pipe()
+	go relay.serve(server)
+	reply, _ := ioutil.ReadAll(client)
+	if !strings.HasPrefix(string(reply), "ERR ") || !strings.Contains(string(reply), "No agent running") {
+		t.Errorf("reply %q", reply)
+	}
+}
+
+func TestDialGPGAgentRedirect(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "real.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	redirect := filepath.Join(dir, "S.gpg-agent")
+	ioutil.WriteFile(redirect, []byte("%Assuan%\nsocket="+socket+"\n"), 0600)
+	conn, err := dialGPGAgent(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
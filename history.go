@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listSessions loads every recorded session, newest first.
+func listSessions() ([]*Session, error) {
+	entries, err := ioutil.ReadDir(getSessionsDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var sessions []*Session
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		session, err := loadSession(entry.Name())
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.After(sessions[j].StartedAt)
+	})
+	return sessions, nil
+}
+
+// pruneSessions applies the history retention settings, deleting old
+// sessions together with their recordings and reports.
+func pruneSessions(globalConfig *GlobalConfig) {
+	sessions, err := listSessions()
+	if err != nil {
+		return
+	}
+
+	maxSessions := globalConfig.History.MaxSessions
+	cutoff := time.Time{}
+	if globalConfig.History.MaxAgeDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -globalConfig.History.MaxAgeDays)
+	}
+
+	for i, session := range sessions {
+		tooMany := maxSessions > 0 && i >= maxSessions
+		tooOld := !cutoff.IsZero() && session.StartedAt.Before(cutoff)
+		if tooMany || tooOld {
+			os.RemoveAll(session.Dir())
+		}
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+func showHistory(args []string) {
+	asJSON := false
+	project := ""
+	limit := 20
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--json":
+			asJSON = true
+		case arg == "--all":
+			limit = 0
+		case strings.HasPrefix(arg, "--project="):
+			project = strings.TrimPrefix(arg, "--project=")
+		case arg == "--project" || arg == "-p":
+			// Without a name, filter on the project in the current directory
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				project = args[i]
+			} else {
+				config, err := loadConfig()
+				if err != nil {
+					fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+					os.Exit(1)
+				}
+				project = config.Project.Name
+			}
+		case arg == "-n" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				log.Fatalf("\033[31m✗\033[0m Invalid count: %s", args[i])
+			}
+			limit = n
+		default:
+			log.Fatalf("\033[31m✗\033[0m Unknown history option: %s", arg)
+		}
+	}
+
+	sessions, err := listSessions()
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to read history:", err)
+	}
+
+	var selected []*Session
+	for _, session := range sessions {
+		if project != "" && session.Project != project {
+			continue
+		}
+		selected = append(selected, session)
+		if limit > 0 && len(selected) >= limit {
+			break
+		}
+	}
+
+	if asJSON {
+		if selected == nil {
+			selected = []*Session{}
+		}
+		data, _ := json.MarshalIndent(selected, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	if len(selected) == 0 {
+		fmt.Println("\033[90mNo sessions recorded yet\033[0m")
+		return
+	}
+
+	fmt.Printf("\033[33m%-32s %-6s %-19s %-8s %-4s %s\033[0m\n", "SESSION", "KIND", "STARTED", "DURATION", "EXIT", "IMAGE")
+	for _, session := range selected {
+		duration := "running"
+		exit := "-"
+		if !session.EndedAt.IsZero() {
+			duration = formatDuration(session.EndedAt.Sub(session.StartedAt))
+			exit = strconv.Itoa(session.ExitCode)
+		}
+		stopped := ""
+		if session.StopReason != "" {
+			stopped = " \033[33mstopped: " + session.StopReason + "\033[0m"
+		}
+		fmt.Printf("%-32s %-6s %-19s %-8s %-4s \033[90m%s\033[0m%s\n",
+			session.ID, session.Kind, session.StartedAt.Format("2006-01-02 15:04:05"), duration, exit, session.Image, stopped)
+	}
+}
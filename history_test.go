@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPruneSessions(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSessions int
+		maxAgeDays  int
+		ages        []int    // age in days of each session
+		want        []string // IDs left
+	}{
+		{"no limits", 0, 0, []int{0, 10, 400}, []string{"s0", "s1", "s2"}},
+		{"max sessions", 2, 0, []int{0, 1, 2, 3}, []string{"s0", "s1"}},
+		{"max age", 0, 30, []int{0, 29, 31, 400}, []string{"s0", "s1"}},
+		{"both", 2, 30, []int{0, 40, 1, 2}, []string{"s0", "s2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_STATE_HOME", t.TempDir())
+			for i, age := range tt.ages {
+				session := &Session{ID: "s" + strconv.Itoa(i), StartedAt: time.Now().AddDate(0, 0, -age).Add(-time.Duration(i) * time.Minute)}
+				if err := os.MkdirAll(session.Dir(), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := session.save(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			globalConfig := &GlobalConfig{}
+			globalConfig.History.MaxSessions = tt.maxSessions
+			globalConfig.History.MaxAgeDays = tt.maxAgeDays
+			pruneSessions(globalConfig)
+
+			sessions, err := listSessions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, session := range sessions {
+				ids = append(ids, session.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("kept %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{42 * time.Second, "0m42s"},
+		{90*time.Second + 400*time.Millisecond, "1m30s"},
+		{2*time.Hour + 5*time.Minute, "2h05m"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Hooks is the [hooks] section. Container hooks run inside the project
+// container before the agent or shell starts: on_create first, as every
+// session gets a fresh container, then on_start and on_attach. pre_run and
+// post_run run on the host around the container.
+type Hooks struct {
+	OnCreate hookList `toml:"on_create"`
+	OnStart  hookList `toml:"on_start"`
+	OnAttach hookList `toml:"on_attach"`
+	PreRun   hookList `toml:"pre_run"`
+	PostRun  hookList `toml:"post_run"`
+}
+
+// Hook is one command. A failing hook stops the session unless fatal = false.
+type Hook struct {
+	Command string `json:"command"`
+	Fatal   *bool  `json:"fatal,omitempty"`
+}
+
+// hookList accepts a command, a list of commands, or { command, fatal } tables.
+type hookList []Hook
+
+// stagedHook is a container hook handed to the container init.
+type stagedHook struct {
+	Stage string `json:"stage"`
+	Hook
+}
+
+const (
+	containerHooksDir = "/run/viber00t/hooks"
+	containerHooksEnv = "VIBER00T_HOOKS"
+)
+
+func (l *hookList) UnmarshalTOML(value interface{}) error {
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case []map[string]interface{}:
+		for _, table := range v {
+			items = append(items, table)
+		}
+	default:
+		items = []interface{}{v}
+	}
+
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			*l = append(*l, Hook{Command: v})
+		case map[string]interface{}:
+			command, _ := v["command"].(string)
+			if command == "" {
+				return fmt.Errorf("hook needs a command")
+			}
+			hook := Hook{Command: command}
+			if fatal, ok := v["fatal"].(bool); ok {
+				hook.Fatal = &fatal
+			}
+			*l = append(*l, hook)
+		default:
+			return fmt.Errorf("hook must be a command or { command, fatal }, got %v", item)
+		}
+	}
+	return nil
+}
+
+func (h Hook) fatal() bool {
+	return h.Fatal == nil || *h.Fatal
+}
+
+func (h Hooks) container() bool {
+	return len(h.OnCreate)+len(h.OnStart)+len(h.OnAttach) > 0
+}
+
+// hooksMounts describes the directory container hooks log to. sessionDir is
+// empty when only describing.
+func hooksMounts(hooks Hooks, sessionDir string) []Mount {
+	if !hooks.container() {
+		return nil
+	}
+	if sessionDir == "" {
+		sessionDir = "<session>"
+	}
+	return []Mount{{
+		Name:   "hooks",
+		Source: filepath.Join(sessionDir, "hooks"),
+		Target: containerHooksDir,
+		Mode:   "rw",
+		Reason: "lifecycle hook logs",
+	}}
+}
+
+// hookArgs passes the container hooks to the container init.
+func hookArgs(config *Config, session *Session) ([]string, error) {
+	hooks := config.Hooks
+	if !hooks.container() {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Join(session.Dir(), "hooks"), 0700); err != nil {
+		return nil, fmt.Errorf("failed to set up hooks: %w", err)
+	}
+
+	var staged []stagedHook
+	for _, hook := range hooks.OnCreate {
+		staged = append(staged, stagedHook{"on_create", hook})
+	}
+	for _, hook := range hooks.OnStart {
+		staged = append(staged, stagedHook{"on_start", hook})
+	}
+	for _, hook := range hooks.OnAttach {
+		staged = append(staged, stagedHook{"on_attach", hook})
+	}
+	data, _ := json.Marshal(staged)
+	return []string{"-e", containerHooksEnv + "=" + string(data)}, nil
+}
+
+// runContainerHooks runs inside the container (viber00t _init --hooks) for
+// the given stages, teeing output to the hooks log in the session. It returns
+// false when a fatal hook failed.
+func runContainerHooks(encoded string, stages ...string) bool {
+	var staged []stagedHook
+	if err := json.Unmarshal([]byte(encoded), &staged); err != nil {
+		fmt.Fprintf(os.Stderr, "viber00t: invalid hooks: %v\n", err)
+		return false
+	}
+	logFile, _ := os.OpenFile(filepath.Join(containerHooksDir, "hooks.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	for _, stage := range stages {
+		for _, hook := range staged {
+			if hook.Stage == stage && !runHook(stage, hook.Hook, nil, logFile) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hostHookEnv tells host hooks which session they belong to.
+func hostHookEnv(config *Config, session *Session, extra ...string) []string {
+	env := []string{
+		"VIBER00T_PROJECT=" + config.Project.Name,
+		"VIBER00T_SESSION=" + session.ID,
+		"VIBER00T_SESSION_DIR=" + session.Dir(),
+	}
+	return append(env, extra...)
+}
+
+// runHostHooks runs the host hooks of a stage in cwd, logging to the session.
+func runHostHooks(stage string, hooks hookList, session *Session, env []string) bool {
+	if len(hooks) == 0 || dryRun {
+		return true
+	}
+	logFile, _ := os.OpenFile(filepath.Join(session.Dir(), "hooks.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if logFile != nil {
+		defer logFile.Close()
+	}
+	for _, hook := range hooks {
+		if !runHook(stage, hook, env, logFile) {
+			return false
+		}
+	}
+	return true
+}
+
+// runHook runs one hook through the shell, returning false if it failed and is fatal.
+func runHook(stage string, hook Hook, env []string, logFile *os.File) bool {
+	fmt.Printf("\033[35m◉\033[0m %s: \033[36m%s\033[0m\n", stage, hook.Command)
+	output := io.Writer(os.Stdout)
+	errors := io.Writer(os.Stderr)
+	if logFile != nil {
+		fmt.Fprintf(logFile, "=== %s %s: %s\n", time.Now().Format(time.RFC3339), stage, redact(hook.Command))
+		output = io.MultiWriter(os.Stdout, logFile)
+		errors = io.MultiWriter(os.Stderr, logFile)
+	}
+
+	cmd := exec.Command("sh", "-c", hook.Command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = output
+	cmd.Stderr = errors
+	cmd.Env = append(os.Environ(), env...)
+	started := time.Now()
+	err := cmd.Run()
+	if logFile != nil {
+		fmt.Fprintf(logFile, "=== exit %d after %.1fs\n", exitCode(err), time.Since(started).Seconds())
+	}
+	if err == nil {
+		return true
+	}
+
+	if hook.fatal() {
+		fmt.Printf("\033[31m✗\033[0m %s hook failed (%v): %s\n", stage, err, hook.Command)
+		return false
+	}
+	fmt.Printf("\033[33m⚠\033[0m  %s hook failed (%v), continuing: %s\n", stage, err, hook.Command)
+	return true
+}
+
+// describe lists the host hooks for the trust prompt.
+func (h Hooks) describe() []string {
+	var settings []string
+	for _, stage := range []struct {
+		name  string
+		hooks hookList
+	}{{"pre_run", h.PreRun}, {"post_run", h.PostRun}} {
+		for _, hook := range stage.hooks {
+			settings = append(settings, fmt.Sprintf("hook: %s runs on the host: %s", stage.name, hook.Command))
+		}
+	}
+	return settings
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestHookListUnmarshal(t *testing.T) {
+	no := false
+	tests := []struct {
+		name    string
+		input   string
+		want    hookList
+		wantErr string
+	}{
+		{
+			name:  "single command",
+			input: `on_start = "make deps"`,
+			want:  hookList{{Command: "make deps"}},
+		},
+		{
+			name:  "list of commands",
+			input: `on_start = ["make deps", "make migrate"]`,
+			want:  hookList{{Command: "make deps"}, {Command: "make migrate"}},
+		},
+		{
+			name:  "inline tables",
+			input: `on_start = [{ command = "make deps" }, { command = "make lint", fatal = false }]`,
+			want:  hookList{{Command: "make deps"}, {Command: "make lint", Fatal: &no}},
+		},
+		{
+			name:  "mixed",
+			input: `on_start = ["make deps", { command = "make lint", fatal = false }]`,
+			want:  hookList{{Command: "make deps"}, {Command: "make lint", Fatal: &no}},
+		},
+		{
+			name:  "array of tables",
+			input: "[[on_start]]\ncommand = \"make deps\"\n[[on_start]]\ncommand = \"make lint\"\nfatal = false\n",
+			want:  hookList{{Command: "make deps"}, {Command: "make lint", Fatal: &no}},
+		},
+		{
+			name:    "table without command",
+			input:   `on_start = [{ fatal = false }]`,
+			wantErr: "needs a command",
+		},
+		{
+			name:    "number",
+			input:   `on_start = 42`,
+			wantErr: "must be a command",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hooks Hooks
+			_, err := toml.Decode(tt.input, &hooks)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hooks.OnStart, tt.want) {
+				t.Errorf("hooks %+v, want %+v", hooks.OnStart, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookFatal(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		hook Hook
+		want bool
+	}{
+		{Hook{Command: "x"}, true},
+		{Hook{Command: "x", Fatal: &yes}, true},
+		{Hook{Command: "x", Fatal: &no}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.hook.fatal(); got != tt.want {
+			t.Errorf("%+v: fatal() = %v, want %v", tt.hook, got, tt.want)
+		}
+	}
+}
+
+func TestHookArgsStages(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	config := &Config{}
+	config.Hooks = Hooks{
+		OnAttach: hookList{{Command: "echo attach"}},
+		OnCreate: hookList{{Command: "echo create"}},
+		OnStart:  hookList{{Command: "echo start"}},
+		PreRun:   hookList{{Command: "echo host"}},
+	}
+	session := &Session{ID: "test-hooks"}
+
+	args, err := hookArgs(config, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != "-e" || !strings.HasPrefix(args[1], containerHooksEnv+"=") {
+		t.Fatalf("args %q", args)
+	}
+	var staged []stagedHook
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(args[1], containerHooksEnv+"=")), &staged); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, hook := range staged {
+		got = append(got, hook.Stage+": "+hook.Command)
+	}
+	// Container stages in the order they run, host hooks left out
+	want := []string{"on_create: echo create", "on_start: echo start", "on_attach: echo attach"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("staged %v, want %v", got, want)
+	}
+
+	if args, err := hookArgs(&Config{}, session); err != nil || args != nil {
+		t.Errorf("no hooks: got %q, %v", args, err)
+	}
+}
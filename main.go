@@ -19,6 +19,7 @@ type Config struct {
 		Name       string
 		Agent      string
 		Privileged bool
+		User       string // "root" (default) or "host"
 	}
 	Install []struct {
 		Packages []string
@@ -28,20 +29,64 @@ type Config struct {
 		Source string
 		Target string
 	}
-	Ports []struct {
-		Host      int
-		Container int
-	}
+	Ports     []PortMapping
+	Services  []Service
+	Ready     []ReadyCheck
+	Hooks     Hooks
+	Tasks     map[string]Task
+	Snapshots struct {
+		Enabled *bool
+		Keep    int
+		Exclude []string
+	}
+	Recording struct {
+		Enabled *bool
+	}
+	Audit struct {
+		Enabled *bool
+	}
+	Mounts      map[string]string
+	SSH         SSHPolicy `toml:"ssh"`
+	Credentials CredentialPolicy
+	GPG         GPGPolicy `toml:"gpg"`
+	Secrets     []SecretSpec
+	Security    SecurityPolicy
+	Network     NetworkPolicy
+	Mask        MaskPolicy
+	Resources   Resources
+	Forward     ForwardPolicy
 }
 
 type GlobalConfig struct {
 	DefaultAgent      string
 	DefaultPrivileged bool
+	DefaultUser       string `toml:"default_user"`
 	DefaultImage      string
 	ClaudeFlags       []string
 	DefaultEnvs       []string
 	DefaultPackages   []string
 	BasePackages      []string // Core packages for all containers
+	Recording         struct {
+		Enabled bool
+	}
+	Audit struct {
+		Enabled bool
+	}
+	History struct {
+		MaxSessions int `toml:"max_sessions"`
+		MaxAgeDays  int `toml:"max_age_days"`
+	}
+	Agents      map[string]AgentDefinition
+	Mounts      map[string]string
+	SSH         SSHPolicy `toml:"ssh"`
+	Credentials CredentialPolicy
+	GPG         GPGPolicy `toml:"gpg"`
+	Security    SecurityPolicy
+	Network     NetworkPolicy
+	Mask        MaskPolicy
+	Resources   Resources
+	Forward     ForwardPolicy
+	Proxy       ProxyConfig
 }
 
 var envTemplates = map[string][]string{
@@ -95,6 +140,7 @@ const defaultConfig = `[project]
 name = "my-project"
 agent = "claude"
 privileged = false
+# user = "host"                # run as your own user (same name/UID, sudo) instead of root
 
 [[install]]
 packages = []
@@ -105,8 +151,128 @@ envs = []  # Available: python, rust, node, go, ruby, java, cpp, php, dotnet
 # target = "/c0de/extra"
 
 [[ports]]
-# host = 3000
+# name = "web"                 # http://web.<project>.localhost:7080 with the global [proxy]
+# host = 3000                  # or "auto" for a free port, or a range "8000-8010"
 # container = 3000
+# host_ip = "127.0.0.1"        # "0.0.0.0" to expose on every interface
+# protocol = "tcp"             # or "udp", "sctp"
+
+[[services]]
+# Sidecars on a network shared with the project container, reachable by name
+# name = "db"
+# image = "postgres:16"
+# env = { POSTGRES_PASSWORD = "dev" }
+# volumes = [{ source = "pgdata", target = "/var/lib/postgresql/data" }]  # named volume or ./path
+# ports = [{ host = 5432, container = 5432 }]
+# healthcheck = "pg_isready -U postgres"
+# health_timeout = "60s"
+# ready = [{ tcp = "5432" }]    # checked from the project container, see [[ready]]
+
+[[ready]]
+# Wait for these before the agent or shell starts
+# tcp = "localhost:3000"       # or http = "http://db:8080/health", command = "...", file = "/tmp/ready"
+# timeout = "60s"
+# interval = "1s"
+# retries = 0                  # give up after this many attempts, 0 = until the timeout
+
+[hooks]
+# A command, a list, or { command = "...", fatal = false } to only warn on failure
+# on_create = "npm ci"         # in the container when it is created (each session)
+# on_start = []                # in the container, every session, before [[ready]]
+# on_attach = []               # in the container, right before the agent or shell
+# pre_run = []                 # on the host, before the container starts
+# post_run = []                # on the host, after it exits ($VIBER00T_EXIT_CODE)
+
+[tasks]
+# Run with 'viber00t run <task> [args...]', list with 'viber00t tasks'
+# test = { command = "go test ./...", description = "Run the tests" }
+# build = { command = "npm run build", workdir = "web", env = { NODE_ENV = "production" } }
+# e2e = { command = "npm run e2e", deps = ["build"], services = true }
+# migrate = { command = "./migrate.sh", parallel = false }  # never next to another task
+
+[snapshots]
+# enabled = true               # snapshot the project before each agent session
+# keep = 20                    # snapshots kept per project
+# exclude = ["node_modules"]   # skipped in tarball snapshots (non-git projects)
+
+[recording]
+# enabled = false              # record sessions as asciicast, see 'viber00t replay'
+
+[audit]
+# enabled = false              # log every command the agent runs, see 'viber00t audit'
+
+[mounts]
+# Built-in home mounts: "rw", "ro" or "off". See 'viber00t mounts'.
+# claude = "rw"                # ~/.claude
+# claude_json = "rw"           # ~/.claude.json
+# gitconfig = "ro"             # ~/.gitconfig
+# git_credentials = "off"      # ~/.git-credentials (plaintext!), use [credentials]
+# ssh = "off"                  # ~/.ssh (private keys!), the agent is forwarded instead
+
+[ssh]
+# agent = true                 # forward the host SSH_AUTH_SOCK
+# allowed_keys = []            # fingerprints ("SHA256:...") the container may use
+# confirm = false              # ask through SSH_ASKPASS before every signature
+# log = false                  # log signing requests to the session
+
+[credentials]
+# bridge = true                # git asks the host for credentials over a socket
+# hosts = ["github.com"]       # hosts the container may get credentials for
+
+[gpg]
+# forward = false              # forward the host gpg-agent for signed commits
+# signing_key = ""             # default: host git config user.signingkey
+# sign_commits = false         # sign every commit (commit.gpgsign)
+# confirm = false              # ask through SSH_ASKPASS before every signature
+
+[security]
+# profile = "strict"           # "strict", "default" (writable image) or "privileged"
+# cap_add = []                 # extra capabilities, e.g. ["SYS_PTRACE"]
+# cap_drop = []
+# no_new_privileges = true
+# seccomp = ""                 # path to a custom seccomp profile
+# read_only = true             # read-only root filesystem (strict)
+# tmpfs = []                   # extra writable paths when read-only
+# pids_limit = 1024            # -1 for unlimited
+
+[forward]
+# Ports services start listening on are forwarded to localhost automatically
+# enabled = true
+# allow = [5173, "8000-8999"]  # only these (default: all)
+# deny = [5432]
+
+[resources]
+# cpus = 2
+# memory = "4g"
+# swap = "6g"                  # memory + swap
+# pids = 1024                  # overrides [security] pids_limit
+# tmpfs = "512m"               # size of each writable tmpfs
+# storage = "20g"              # needs a storage driver with quota support
+# max_duration = "4h"          # stop the session after this long
+# idle_timeout = "30m"         # stop after no terminal activity
+# Per run: viber00t --memory 8g --max-duration 1h
+
+[mask]
+# Project files hidden from the container (shadowed by empty files/directories).
+# Built in: .env, .env.*, *.pem, *.key, id_rsa*, *.tfstate, .npmrc, .aws/ and more
+# patterns = ["*.sqlite", "config/master.key"]
+# except = [".env.example"]
+# defaults = true              # false drops the built-in patterns
+
+[network]
+# mode = "full"                # "full", "none" or "allowlist" (HTTP(S) proxy, no DNS)
+# allow = ["github.com", "*.npmjs.org"]  # the agent's API hosts are always allowed
+# networks = ["shop"]          # shared with other projects, reachable as <project> (mode "full")
+# aliases = ["api"]            # more names for this container on those networks
+# dns = ["1.1.1.1"]
+# hosts = { "db.local" = "10.0.0.5" }
+# host_access = true           # host.containers.internal reaches the host
+
+[[secrets]]
+# name = "openai"
+# from_env = "OPENAI_API_KEY"  # or from_file, from_pass, from_command
+# as = "env"                   # "env" or "file" (/run/secrets/<name>)
+# variable = "OPENAI_API_KEY"  # name inside the container (default: NAME)
 `
 
 const defaultGlobalConfig = `# viber00t global configuration
@@ -116,6 +282,9 @@ const defaultGlobalConfig = `# viber00t global configuration
 # Override default agent (default: "claude")
 # default_agent = "claude"
 
+# Run as a user matching your host account instead of root (default: "root")
+# default_user = "host"
+
 # Override privileged mode (default: false)
 # default_privileged = false
 
@@ -130,6 +299,82 @@ const defaultGlobalConfig = `# viber00t global configuration
 
 # Default packages for all projects
 # default_packages = []
+
+# Record every session as an asciicast (projects can opt out)
+# [recording]
+# enabled = true
+
+# Audit every command run in every project (projects can opt out)
+# [audit]
+# enabled = true
+
+# Session history retention, older sessions and their recordings are deleted
+# (-1 keeps them forever)
+# [history]
+# max_sessions = 500
+# max_age_days = 90
+
+# Agent definitions: how to launch an agent and resume its conversations.
+# Built in: claude, codex, aider
+# [agents.claude]
+# command = "claude"
+# continue = ["--continue"]   # used by 'viber00t resume' for the latest session
+# resume = ["--resume", "{id}"]         # used to resume a session's conversation
+# session_id = ["--session-id", "{id}"] # gives each session's conversation a known ID
+# hosts = ["api.anthropic.com"] # always reachable under a network allowlist
+
+# Built-in home mounts for all projects: "rw", "ro" or "off"
+# (Viber00t.toml [mounts] takes precedence)
+# [mounts]
+# ssh = "off"
+# git_credentials = "off"
+
+# Git credential bridge defaults (Viber00t.toml [credentials] takes precedence)
+# [credentials]
+# bridge = true
+# hosts = ["github.com", "*.gitlab.example.com"]
+
+# GPG agent forwarding for signed commits (Viber00t.toml [gpg] takes precedence)
+# [gpg]
+# forward = true
+# sign_commits = true
+
+# Default security profile: "strict", "default" or "privileged"
+# (Viber00t.toml [security] and privileged = true take precedence)
+# [security]
+# profile = "strict"
+# seccomp = "~/.config/viber00t/seccomp.json"
+
+# Network policy for all projects, project allow lists are added to this one
+# [network]
+# mode = "allowlist"
+# allow = ["github.com", "*.github.com", "pypi.org", "files.pythonhosted.org"]
+
+# Shared reverse proxy: http://<service>.<project>.localhost:7080
+# [proxy]
+# enabled = true
+# port = 7080
+
+# Automatic port forwarding (on by default)
+# [forward]
+# deny = [22, 5432]
+
+# Default resource limits (Viber00t.toml [resources] and flags take precedence)
+# [resources]
+# memory = "8g"
+# cpus = 4
+# idle_timeout = "1h"
+
+# Files to mask in every project, added to the built-in set
+# [mask]
+# patterns = ["*.sqlite"]
+
+# SSH agent forwarding defaults (Viber00t.toml [ssh] takes precedence)
+# [ssh]
+# agent = true
+# allowed_keys = ["SHA256:..."]
+# confirm = true
+# log = true
 `
 
 func getXDGConfigHome() string {
@@ -153,23 +398,56 @@ func getXDGStateHome() string {
 	return filepath.Join(os.Getenv("HOME"), ".local", "state")
 }
 
+// dryRun prints the podman command instead of running it
+var dryRun bool
+
 func main() {
-	if len(os.Args) < 2 {
+	// In-container helpers take their arguments verbatim
+	if len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "_") {
+		runHelper(os.Args[1], os.Args[2:])
+		return
+	}
+
+	// --dry-run and resource limits are ours when they come first, everything
+	// from the first other argument on is passed through to the agent
+	args, err := parseFlags(os.Args[1:], false)
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	if len(args) == 0 {
 		runContainer([]string{})
 		return
 	}
 
-	// Check for help/version flags anywhere in args
-	for _, arg := range os.Args[1:] {
-		if arg == "help" || arg == "-h" || arg == "--help" {
-			showHelp()
-			return
+	switch args[0] {
+	case "help", "-h", "--help":
+		showHelp()
+		return
+	case "version", "-v", "--version":
+		showVersion()
+		return
+	case "run":
+		// Flags before the task name are ours, the rest belongs to the task
+		if args, err = parseFlags(args[1:], false); err != nil {
+			log.Fatal("\033[31m✗\033[0m ", err)
 		}
-		if arg == "version" || arg == "-v" || arg == "--version" {
-			showVersion()
-			return
+		runTask(args)
+		return
+	}
+
+	// Our own commands take our flags and help anywhere
+	if ownCommands[args[0]] {
+		if args, err = parseFlags(args, true); err != nil {
+			log.Fatal("\033[31m✗\033[0m ", err)
+		}
+		for _, arg := range args[1:] {
+			if arg == "-h" || arg == "--help" {
+				showHelp()
+				return
+			}
 		}
 	}
+	os.Args = append(os.Args[:1], args...)
 
 	switch os.Args[1] {
 	case "init":
@@ -183,12 +461,93 @@ func main() {
 		cleanImages(cleanAll)
 	case "shell":
 		runShell()
+	case "snapshots":
+		listSnapshots()
+	case "rollback":
+		rollback(os.Args[2:])
+	case "changes":
+		showChanges(os.Args[2:])
+	case "replay":
+		replay(os.Args[2:])
+	case "history":
+		showHistory(os.Args[2:])
+	case "resume":
+		resumeSession(os.Args[2:])
+	case "mounts":
+		showMounts()
+	case "trust":
+		trustProject(os.Args[2:])
+	case "untrust":
+		untrustProject(os.Args[2:])
+	case "audit":
+		showAudit(os.Args[2:])
+	case "port", "ports":
+		showPorts()
+	case "proxy":
+		showProxy(os.Args[2:])
+	case "services":
+		showServices()
+	case "tasks":
+		showTasks()
 	default:
 		// Pass all arguments through to claude
 		runContainer(os.Args[1:])
 	}
 }
 
+// ownCommands are the subcommands, anything else is an argument for the agent.
+var ownCommands = map[string]bool{
+	"init": true, "clean": true, "shell": true, "snapshots": true, "rollback": true,
+	"changes": true, "replay": true, "history": true, "resume": true, "mounts": true,
+	"trust": true, "untrust": true, "audit": true, "port": true, "ports": true,
+	"proxy": true, "services": true, "tasks": true,
+}
+
+// parseFlags takes our flags off the front of args, or from anywhere when all
+// is set, and returns the rest in order.
+func parseFlags(args []string, all bool) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--dry-run" {
+			dryRun = true
+			continue
+		}
+		used, err := parseResourceFlag(args, i)
+		if err != nil {
+			return nil, err
+		}
+		if used > 0 {
+			i += used - 1
+			continue
+		}
+		if !all {
+			return append(rest, args[i:]...), nil
+		}
+		rest = append(rest, args[i])
+	}
+	return rest, nil
+}
+
+// runHelper dispatches the hidden commands: the in-container helpers and the
+// background proxy.
+func runHelper(name string, args []string) {
+	switch name {
+	case "_git-credential":
+		gitCredentialHelper(args)
+	case "_init":
+		containerInit(args)
+	case "_audit":
+		auditShellHook(args)
+	case "_proxy":
+		runProxy(args)
+	case "_tasks":
+		runTasks()
+	default:
+		fmt.Fprintf(os.Stderr, "viber00t: unknown helper %s\n", name)
+		os.Exit(2)
+	}
+}
+
 func showHelp() {
 	banner := `
 ╦  ╦╦╔╗ ╔═╗╦═╗╔═╗╔═╗╔╦╗
@@ -206,6 +565,23 @@ func showHelp() {
 	fmt.Println("  viber00t shell        \033[90m# Interactive bash shell\033[0m")
 	fmt.Println("  viber00t clean        \033[90m# Clean project images\033[0m")
 	fmt.Println("  viber00t clean --all  \033[90m# Clean ALL images (including base)\033[0m")
+	fmt.Println("  viber00t snapshots    \033[90m# List workspace snapshots\033[0m")
+	fmt.Println("  viber00t rollback [id]\033[90m# Restore a snapshot (default: latest)\033[0m")
+	fmt.Println("  viber00t changes [id] \033[90m# Files the last session touched (--json)\033[0m")
+	fmt.Println("  viber00t replay [id]  \033[90m# Replay a recorded session (--speed 2)\033[0m")
+	fmt.Println("  viber00t history      \033[90m# Past sessions (--project [name], --json)\033[0m")
+	fmt.Println("  viber00t resume [id]  \033[90m# Resume an agent conversation (--last)\033[0m")
+	fmt.Println("  viber00t audit [id]   \033[90m# Commands run in a session (--failed, --grep, --source)\033[0m")
+	fmt.Println("  viber00t port         \033[90m# Published ports of the running containers\033[0m")
+	fmt.Println("  viber00t services     \033[90m# State of the project's [[services]]\033[0m")
+	fmt.Println("  viber00t run <task>   \033[90m# Run a [tasks] entry and its deps in the container\033[0m")
+	fmt.Println("  viber00t tasks        \033[90m# List the project's tasks\033[0m")
+	fmt.Println("  viber00t proxy        \033[90m# <service>.<project>.localhost routes (stop to shut it down)\033[0m")
+	fmt.Println("  viber00t mounts       \033[90m# Show what the container can see\033[0m")
+	fmt.Println("  viber00t trust        \033[90m# Accept this project's risky settings (--list)\033[0m")
+	fmt.Println("  viber00t untrust      \033[90m# Forget trust for this project\033[0m")
+	fmt.Println("  viber00t --memory 4g  \033[90m# Per-run limits: --cpus --swap --pids --max-duration --idle-timeout\033[0m")
+	fmt.Println("  viber00t --dry-run    \033[90m# Print the podman command instead (also: shell, run)\033[0m")
 	fmt.Println()
 	fmt.Println("\033[33mENVIRONMENTS:\033[0m")
 	fmt.Println("  python, rust, node, go, ruby, java, cpp, php, dotnet")
@@ -268,6 +644,8 @@ func loadGlobalConfig() (*GlobalConfig, error) {
 	config.DefaultImage = "viber00t/base:latest"
 	config.ClaudeFlags = []string{"--dangerously-skip-permissions"}
 	config.BasePackages = defaultBasePackages // Use defaults from code
+	config.History.MaxSessions = 500
+	config.History.MaxAgeDays = 90
 
 	// Load config file if it exists and merge overrides
 	if data, err := ioutil.ReadFile(configPath); err == nil {
@@ -280,6 +658,9 @@ func loadGlobalConfig() (*GlobalConfig, error) {
 			if fileConfig.DefaultImage != "" {
 				config.DefaultImage = fileConfig.DefaultImage
 			}
+			if fileConfig.DefaultUser != "" {
+				config.DefaultUser = fileConfig.DefaultUser
+			}
 			if fileConfig.DefaultPrivileged {
 				config.DefaultPrivileged = fileConfig.DefaultPrivileged
 			}
@@ -290,6 +671,29 @@ func loadGlobalConfig() (*GlobalConfig, error) {
 			if len(fileConfig.BasePackages) > 0 {
 				config.BasePackages = append(config.BasePackages, fileConfig.BasePackages...)
 			}
+			if fileConfig.Recording.Enabled {
+				config.Recording.Enabled = true
+			}
+			if fileConfig.Audit.Enabled {
+				config.Audit.Enabled = true
+			}
+			if fileConfig.History.MaxSessions != 0 {
+				config.History.MaxSessions = fileConfig.History.MaxSessions
+			}
+			if fileConfig.History.MaxAgeDays != 0 {
+				config.History.MaxAgeDays = fileConfig.History.MaxAgeDays
+			}
+			config.Agents = fileConfig.Agents
+			config.Mounts = fileConfig.Mounts
+			config.SSH = fileConfig.SSH
+			config.Credentials = fileConfig.Credentials
+			config.GPG = fileConfig.GPG
+			config.Security = fileConfig.Security
+			config.Network = fileConfig.Network
+			config.Mask = fileConfig.Mask
+			config.Resources = fileConfig.Resources
+			config.Forward = fileConfig.Forward
+			config.Proxy = fileConfig.Proxy
 			// Copy over other fields
 			config.DefaultEnvs = fileConfig.DefaultEnvs
 			config.DefaultPackages = fileConfig.DefaultPackages
@@ -337,6 +741,14 @@ func getConfigHash(config *Config) string {
 	h.Write([]byte(config.Project.Agent))
 	h.Write([]byte(fmt.Sprintf("%v", config.Project.Privileged)))
 
+	// The image bakes in the container user
+	globalConfig, _ := loadGlobalConfig()
+	u := resolveUser(config, globalConfig)
+	h.Write([]byte(u.Name + ":" + u.UID + ":" + u.GID))
+	h.Write([]byte(fmt.Sprintf("audit=%v", auditEnabled(config, globalConfig))))
+	// and is rebuilt along with its base image
+	h.Write([]byte(getBaseImageName(baseImageEnv(config), globalConfig)))
+
 	// Hash install packages and envs
 	if len(config.Install) > 0 {
 		for _, pkg := range config.Install[0].Packages {
@@ -360,8 +772,24 @@ func getProjectImageName(config *Config) string {
 	return fmt.Sprintf("viber00t/%s:%s", config.Project.Name, hash)
 }
 
+// baseImageEnv is the environment whose base image the project builds on.
+func baseImageEnv(config *Config) string {
+	// Use first environment as primary (can extend later for multi-env)
+	if len(config.Install) > 0 && len(config.Install[0].Envs) > 0 {
+		return config.Install[0].Envs[0]
+	}
+	return "base"
+}
+
+// getBaseImageName tags base images with their Dockerfile, so a change to how
+// they are built (like moving toolchains to /opt) gets a fresh image.
+func getBaseImageName(env string, globalConfig *GlobalConfig) string {
+	sum := sha256.Sum256([]byte(generateBaseDockerfile(env, globalConfig)))
+	return fmt.Sprintf("viber00t:%s-base-%x", env, sum[:6])
+}
+
 func buildOrGetBaseImage(env string, globalConfig *GlobalConfig) (string, error) {
-	baseImageName := fmt.Sprintf("viber00t:%s-base", env)
+	baseImageName := getBaseImageName(env, globalConfig)
 
 	// Check if base image already exists
 	checkCmd := exec.Command("podman", "images", "-q", baseImageName)
@@ -412,8 +840,11 @@ RUN apt-get update && \
     apt-get install -y --no-install-recommends \
     ` + strings.Join(basePackages, " \\\n    ") + ` && \
 
-# Install Claude Code
-RUN curl -fsSL https://claude.ai/install.sh | bash
+# Install Claude Code in a shared location, usable by root and the host user
+RUN curl -fsSL https://claude.ai/install.sh | HOME=/opt/claude bash && \
+    chmod -R a+rX /opt/claude && \
+    ln -sf /opt/claude/.local/bin/claude /usr/local/bin/claude
+ENV DISABLE_AUTOUPDATER=1
 `
 
 	// Add environment-specific installations
@@ -425,11 +856,12 @@ RUN apt-get update && \
     apt-get install -y --no-install-recommends \
     pkg-config libssl-dev build-essential
 
-RUN curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh -s -- -y --default-toolchain stable && \
+ENV RUSTUP_HOME=/opt/rust/rustup CARGO_HOME=/opt/rust/cargo
+ENV PATH="/opt/rust/cargo/bin:${PATH}"
+RUN curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh -s -- -y --no-modify-path --default-toolchain stable && \
     rustup component add rustfmt clippy rust-analyzer rust-src && \
-    cargo install cargo-watch cargo-edit cargo-expand
-
-ENV PATH="/root/.cargo/bin:${PATH}"
+    cargo install cargo-watch cargo-edit cargo-expand && \
+    chmod -R a+rwX /opt/rust
 ENV RUST_BACKTRACE=1
 `
 	case "python":
@@ -508,6 +940,10 @@ RUN echo '#!/bin/bash\ncd /c0de/${VIBER00T_PROJECT:-project}\nexec "$@"' > /entr
 ENTRYPOINT ["/entrypoint.sh"]
 CMD ["claude"]
 `
+	dockerfile += resolveUser(config, globalConfig).dockerfile()
+	if auditEnabled(config, globalConfig) {
+		dockerfile += auditDockerfile()
+	}
 
 	return dockerfile
 }
@@ -549,15 +985,8 @@ func buildProjectImage(config *Config) error {
 		return nil
 	}
 
-	// Determine which base image to use
-	baseEnv := "base" // Default to base if no env specified
-	if len(config.Install) > 0 && len(config.Install[0].Envs) > 0 {
-		// Use first environment as primary (can extend later for multi-env)
-		baseEnv = config.Install[0].Envs[0]
-	}
-
 	// Build or get the base image
-	baseImage, err := buildOrGetBaseImage(baseEnv, globalConfig)
+	baseImage, err := buildOrGetBaseImage(baseImageEnv(config), globalConfig)
 	if err != nil {
 		return fmt.Errorf("failed to build/get base image: %w", err)
 	}
@@ -585,6 +1014,9 @@ func buildProjectImage(config *Config) error {
 	if err := ioutil.WriteFile(dockerfilePath, []byte(dockerfile), 0644); err != nil {
 		return fmt.Errorf("failed to write Dockerfile: %w", err)
 	}
+	if err := writeAuditHook(buildDir); err != nil {
+		return fmt.Errorf("failed to write audit hook: %w", err)
+	}
 
 	// Build image
 	cmd := exec.Command("podman", "build", "-t", imageName, buildDir)
@@ -608,263 +1040,403 @@ func buildProjectImage(config *Config) error {
 }
 
 func runContainer(extraArgs []string) {
+	launchAgent(extraArgs, nil, "")
+}
+
+// launchAgent starts the project's agent. When resuming, the previous
+// session's identity is reused and the agent is started in the given mode.
+func launchAgent(extraArgs []string, resume *Session, mode string) {
 	config, err := loadConfig()
 	if err != nil {
 		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
 		os.Exit(1)
 	}
 
+	// A cloned repo must not get host access without the user agreeing to it
+	cwd, _ := os.Getwd()
+	requireTrust(config, cwd)
+
 	// Build project-specific image
-	if err := buildProjectImage(config); err != nil {
-		log.Fatal("\033[31m✗\033[0m Failed to build image:", err)
+	if !dryRun {
+		if err := buildProjectImage(config); err != nil {
+			log.Fatal("\033[31m✗\033[0m Failed to build image:", err)
+		}
 	}
 
-	cwd, _ := os.Getwd()
 	containerName := fmt.Sprintf("viber00t-%s", filepath.Base(cwd))
 
-	// Check if container already exists
-	checkCmd := exec.Command("podman", "ps", "-a", "--format", "{{.Names}}")
-	output, _ := checkCmd.Output()
-	if strings.Contains(string(output), containerName) {
-		fmt.Printf("\033[33m⟳\033[0m Removing existing container %s\n", containerName)
-		exec.Command("podman", "rm", "-f", containerName).Run()
+	// Load global config for flags
+	globalConfig, _ := loadGlobalConfig()
+
+	session, err := startSession(config, globalConfig, "agent", cwd)
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to start session:", err)
+	}
+	if resume != nil {
+		session.ResumedFrom = rootSession(resume)
 	}
 
-	args := []string{
-		"run", "-it",
-		"--name", containerName,
-		"--hostname", "viber00t",
-		"--userns=keep-id:uid=0,gid=0",
-		"-v", fmt.Sprintf("%s:/c0de/%s", cwd, config.Project.Name),
+	// Snapshot the workspace so a bad session can be rolled back
+	if snapshotsEnabled(config) && !dryRun {
+		if snap, err := takeSnapshot(config, cwd, "session "+session.ID); err != nil {
+			fmt.Printf("\033[33m⚠\033[0m  Snapshot failed: %v\n", err)
+		} else {
+			session.Snapshot = snap.ID
+			fmt.Printf("\033[35m◉\033[0m Snapshot %s saved (%s)\n", snap.ID, snap.Kind)
+		}
 	}
 
-	// Mount Claude config directory if it exists
-	claudeDir := filepath.Join(os.Getenv("HOME"), ".claude")
-	if _, err := os.Stat(claudeDir); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.claude:rw", claudeDir))
+	// Record what the project looks like so the session's changes can be reported
+	if !dryRun {
+		recordManifest(session)
 	}
 
-	// Mount claude.json config file if it exists
-	claudeJSON := filepath.Join(os.Getenv("HOME"), ".claude.json")
-	if _, err := os.Stat(claudeJSON); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.claude.json:rw", claudeJSON))
+	// Check if container already exists
+	if !dryRun {
+		removeContainer(containerName)
 	}
 
-	// Mount git config
-	gitConfig := filepath.Join(os.Getenv("HOME"), ".gitconfig")
-	if _, err := os.Stat(gitConfig); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.gitconfig:ro", gitConfig))
+	args, err := containerArgs(config, globalConfig, session, cwd, containerName)
+	if err != nil {
+		session.finish(-1)
+		log.Fatal("\033[31m✗\033[0m ", err)
 	}
+	args = append(args, "-e", "VIBER00T_SESSION="+rootSession(session))
 
-	// Mount git credentials
-	gitCreds := filepath.Join(os.Getenv("HOME"), ".git-credentials")
-	if _, err := os.Stat(gitCreds); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.git-credentials:ro", gitCreds))
+	// Use project-specific image
+	imageName := getProjectImageName(config)
+	args = append(args, imageName)
+
+	// Run with specified agent and flags
+	if config.Project.Agent != "" {
+		// Give the conversation a known ID so 'viber00t resume' can get back to it
+		def := getAgentDefinition(config.Project.Agent, globalConfig)
+		switch {
+		case resume != nil && mode == "resume":
+			session.Conversation = resume.Conversation
+		case mode == "" && def.resumes():
+			session.Conversation = newConversationID()
+		}
+		agentCmd := agentCommand(config, globalConfig, mode, session.Conversation, extraArgs)
+		args = append(args, initCommand(config, globalConfig, agentCmd)...)
+		session.Command = agentCmd
 	}
+	session.Container = containerName
+	session.Image = imageName
 
-	// Mount SSH keys for git
-	sshDir := filepath.Join(os.Getenv("HOME"), ".ssh")
-	if _, err := os.Stat(sshDir); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.ssh:ro", sshDir))
+	if dryRun {
+		printDryRun(args, session)
+		return
 	}
 
-	// Add privileged mode if requested
-	if config.Project.Privileged {
-		args = append(args, "--privileged", "--security-opt", "label=disable")
-		// Mount docker socket if it exists
-		if _, err := os.Stat("/var/run/docker.sock"); err == nil {
-			args = append(args, "-v", "/var/run/docker.sock:/var/run/docker.sock")
+	fmt.Printf("\033[35m◉\033[0m Starting viber00t for \033[36m%s\033[0m...\n", config.Project.Name)
+	fmt.Println("\033[90m───────────────────────────────────\033[0m")
+
+	cmd := exec.Command("podman", args...)
+	runErr := runAttached(cmd, config, globalConfig, session)
+	runHostHooks("post_run", config.Hooks.PostRun, session,
+		hostHookEnv(config, session, fmt.Sprintf("VIBER00T_EXIT_CODE=%d", exitCode(runErr))))
+
+	// Report what the session did to the project
+	fmt.Println("\033[90m───────────────────────────────────\033[0m")
+	if report, err := writeChangeReport(session); err != nil {
+		fmt.Printf("\033[33m⚠\033[0m  Failed to build change report: %v\n", err)
+	} else {
+		fmt.Printf("\033[35m◉\033[0m Session %s: %s\n", session.ID, report.summary())
+		if report.touched() > 0 {
+			fmt.Println("\033[90mRun 'viber00t changes' for details\033[0m")
 		}
 	}
+	session.finish(exitCode(runErr))
 
-	// Add volumes
-	for _, vol := range config.Volumes {
-		if vol.Source != "" && vol.Target != "" {
-			source := expandPath(vol.Source)
-			args = append(args, "-v", fmt.Sprintf("%s:%s:Z", source, vol.Target))
-		}
+	if runErr != nil {
+		log.Fatal("\033[31m✗\033[0m Container failed:", runErr)
 	}
+}
 
-	// Add ports
-	for _, port := range config.Ports {
-		if port.Host != 0 && port.Container != 0 {
-			args = append(args, "-p", fmt.Sprintf("%d:%d", port.Host, port.Container))
-		}
+// containerArgs builds the podman run arguments shared by every way of
+// entering the project environment: mounts, privileges, ports and base env.
+// On error the caller finishes the session, which undoes what was set up.
+func containerArgs(config *Config, globalConfig *GlobalConfig, session *Session, cwd, containerName string) ([]string, error) {
+	if err := checkMountPolicy(globalConfig.Mounts, "global config"); err != nil {
+		return nil, err
+	}
+	if err := checkMountPolicy(config.Mounts, "Viber00t.toml"); err != nil {
+		return nil, err
+	}
+	if err := checkSecurityPolicy(globalConfig.Security, "global config"); err != nil {
+		return nil, err
+	}
+	if err := checkSecurityPolicy(config.Security, "Viber00t.toml"); err != nil {
+		return nil, err
+	}
+	if err := checkUserMode(globalConfig.DefaultUser, "global config"); err != nil {
+		return nil, err
+	}
+	if err := checkUserMode(config.Project.User, "Viber00t.toml"); err != nil {
+		return nil, err
+	}
+	if err := checkNetworkPolicy(globalConfig.Network, "global config"); err != nil {
+		return nil, err
+	}
+	if err := checkNetworkPolicy(config.Network, "Viber00t.toml"); err != nil {
+		return nil, err
 	}
 
-	// Environment variables
-	args = append(args, "-e", "TERM=xterm-256color")
-	args = append(args, "-e", "VIBER00T_PROJECT="+config.Project.Name)
-	args = append(args, "-e", "IS_SANDBOX=true")
+	// pre_run hooks on the host, before anything is started
+	if !runHostHooks("pre_run", config.Hooks.PreRun, session, hostHookEnv(config, session)) {
+		return nil, fmt.Errorf("pre_run hook failed")
+	}
 
-	// Create package install script if needed
-	if len(config.Install) > 0 {
-		var allPackages []string
+	args := []string{
+		"run", "-it",
+		"--name", containerName,
+		"--hostname", "viber00t",
+	}
+	args = append(args, resolveUser(config, globalConfig).args()...)
+
+	// Project directory, home mounts allowed by the policy, and volumes
+	mounts := resolveMounts(config, globalConfig, cwd, session.Dir())
+	args = append(args, mountArgs(mounts)...)
 
-		// Add explicit packages
-		if len(config.Install[0].Packages) > 0 {
-			allPackages = append(allPackages, config.Install[0].Packages...)
+	// Shadow local secrets inside the project mount
+	masked := findMasked(resolveMaskPolicy(config, globalConfig), cwd)
+	printMasked(masked)
+	args = append(args, maskArgs(masked, "/c0de/"+config.Project.Name)...)
+
+	// Agents, relays and proxies on the host are only started for real runs:
+	// a dry run may be showing a project that is not trusted yet
+
+	// Forward the SSH agent rather than handing over private keys
+	sshPolicy := resolveSSHPolicy(config, globalConfig)
+	if !dryRun {
+		if err := setupSSH(sshPolicy, mounts, session); err != nil {
+			return nil, fmt.Errorf("failed to set up SSH forwarding: %w", err)
 		}
+	}
+	if sshPolicy.forwardAgent() {
+		args = append(args, "-e", "SSH_AUTH_SOCK="+containerSSHAgentSock)
+	}
 
-		// Expand environment templates
-		if len(config.Install[0].Envs) > 0 {
-			for _, env := range config.Install[0].Envs {
-				if packages, ok := envTemplates[env]; ok {
-					allPackages = append(allPackages, packages...)
-				}
+	// Answer git credential requests from the host instead of exposing ~/.git-credentials
+	var gitConfig [][2]string
+	credentialPolicy := resolveCredentialPolicy(config, globalConfig)
+	if credentialPolicy.enabled() {
+		if !dryRun {
+			if err := startCredentialBroker(credentialPolicy, session); err != nil {
+				return nil, err
 			}
 		}
+		gitConfig = append(gitConfig, credentialGitConfig()...)
+	}
 
-		if len(allPackages) > 0 {
-			packages := strings.Join(allPackages, " ")
-			args = append(args, "-e", "VIBER00T_INSTALL="+packages)
+	// Sign commits through the host gpg-agent
+	if gpgPolicy := resolveGPGPolicy(config, globalConfig); gpgPolicy.enabled() {
+		if !dryRun {
+			if err := setupGPG(gpgPolicy, session); err != nil {
+				return nil, fmt.Errorf("failed to set up gpg forwarding: %w", err)
+			}
 		}
+		gitConfig = append(gitConfig, gpgGitConfig(gpgPolicy)...)
+	}
+	for _, env := range gitConfigEnv(gitConfig) {
+		args = append(args, "-e", env)
 	}
 
-	// Load global config for flags
-	globalConfig, _ := loadGlobalConfig()
-
-	// Use project-specific image
-	imageName := getProjectImageName(config)
-	args = append(args, imageName)
+	// Stream executed commands to the session audit log
+	if auditEnabled(config, globalConfig) {
+		auditArgs, err := startAuditLog(session)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, auditArgs...)
+	}
 
-	// Run with specified agent and flags
-	if config.Project.Agent != "" {
-		agentCmd := []string{config.Project.Agent}
+	// Secrets travel as podman secrets, never as plain arguments
+	secretArgs, err := setupSecrets(config.Secrets, session, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, secretArgs...)
 
-		// Add claude specific flags
-		if config.Project.Agent == "claude" && len(globalConfig.ClaudeFlags) > 0 {
-			agentCmd = append(agentCmd, globalConfig.ClaudeFlags...)
-		}
+	// Capabilities, seccomp and read-only root from the security profile,
+	// sized by the resource limits
+	resources := resolveResources(config, globalConfig)
+	if _, _, err := resources.timeouts(); err != nil {
+		return nil, err
+	}
+	security := resolveSecurity(config, globalConfig)
+	if resources.Pids != 0 {
+		security.PidsLimit = resources.Pids
+	}
+	security.TmpfsSize = resources.Tmpfs
+	args = append(args, security.args()...)
+	args = append(args, resources.args()...)
 
-		// Add any extra arguments passed through from the command line
-		if len(extraArgs) > 0 {
-			agentCmd = append(agentCmd, extraArgs...)
-		}
+	// Sidecar services, on a network shared with the project container
+	networkPolicy := resolveNetworkPolicy(config, globalConfig)
+	network, servicePorts, err := startServices(config, networkPolicy, session, cwd)
+	if err != nil {
+		return nil, err
+	}
+	if network != "" {
+		args = append(args, serviceLabels(config)...)
+	}
 
-		args = append(args, agentCmd...)
+	// Network isolation and the egress allowlist proxy
+	netArgs, err := networkArgs(networkPolicy, network, config, session)
+	if err != nil {
+		return nil, err
 	}
+	args = append(args, netArgs...)
 
-	fmt.Printf("\033[35m◉\033[0m Starting viber00t for \033[36m%s\033[0m...\n", config.Project.Name)
-	fmt.Println("\033[90m───────────────────────────────────\033[0m")
+	// Published ports, loopback only unless host_ip says otherwise
+	ports, err := resolvePorts(config.Ports)
+	if err != nil {
+		return nil, err
+	}
+	var published []portBinding
+	for _, port := range ports {
+		if networkPolicy.Mode != "full" {
+			fmt.Printf("\033[33m⚠\033[0m  Port %s not published, network mode %q has no host network\n", port, networkPolicy.Mode)
+			continue
+		}
+		args = append(args, "-p", port.arg())
+		published = append(published, port)
+		session.Ports = append(session.Ports, port.String())
+		if port.Auto {
+			fmt.Printf("\033[35m◉\033[0m Port %s\n", port)
+		}
+	}
 
-	cmd := exec.Command("podman", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// <service>.<project>.localhost through the shared proxy
+	routes := registerRoutes(config, globalConfig, session, append(published, servicePorts...))
 
-	if err := cmd.Run(); err != nil {
-		log.Fatal("\033[31m✗\033[0m Container failed:", err)
+	if enabled := config.Forward.Enabled; enabled != nil && *enabled && networkPolicy.Mode != "full" {
+		fmt.Printf("\033[33m⚠\033[0m  Ports not forwarded, network mode %q has no host network\n", networkPolicy.Mode)
+	}
+	// Forward whatever else starts listening, through the relay rather than the network
+	if forwardPolicy := resolveForwardPolicy(config, globalConfig); forwardPolicy.enabled() && !dryRun {
+		if err := startForwarder(forwardPolicy, published, routes, session); err != nil {
+			return nil, err
+		}
 	}
-}
 
-func runShell() {
-	config, err := loadConfig()
+	// Readiness checks, run by the container init before the agent starts
+	probes, err := resolveReadyChecks(config)
 	if err != nil {
-		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
-		os.Exit(1)
+		return nil, err
 	}
+	args = append(args, readyArgs(probes)...)
 
-	// Build project-specific image
-	if err := buildProjectImage(config); err != nil {
-		log.Fatal("\033[31m✗\033[0m Failed to build image:", err)
+	// Lifecycle hooks, also run by the container init
+	hooks, err := hookArgs(config, session)
+	if err != nil {
+		return nil, err
 	}
+	args = append(args, hooks...)
 
-	cwd, _ := os.Getwd()
-	containerName := fmt.Sprintf("viber00t-shell-%s", filepath.Base(cwd))
+	// Environment variables
+	args = append(args, "-e", "TERM=xterm-256color")
+	args = append(args, "-e", "VIBER00T_PROJECT="+config.Project.Name)
+	args = append(args, "-e", "IS_SANDBOX=true")
 
-	// Check if container already exists
+	return args, nil
+}
+
+func removeContainer(containerName string) {
 	checkCmd := exec.Command("podman", "ps", "-a", "--format", "{{.Names}}")
 	output, _ := checkCmd.Output()
 	if strings.Contains(string(output), containerName) {
 		fmt.Printf("\033[33m⟳\033[0m Removing existing container %s\n", containerName)
 		exec.Command("podman", "rm", "-f", containerName).Run()
 	}
+}
 
-	args := []string{
-		"run", "-it",
-		"--name", containerName,
-		"--hostname", "viber00t",
-		"--userns=keep-id:uid=0,gid=0",
-		"-v", fmt.Sprintf("%s:/c0de/%s", cwd, config.Project.Name),
-	}
-
-	// Mount Claude config directory if it exists
-	claudeDir := filepath.Join(os.Getenv("HOME"), ".claude")
-	if _, err := os.Stat(claudeDir); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.claude:rw", claudeDir))
+// quoteCommand renders a command for the shell, with secrets masked.
+func quoteCommand(name string, args []string) string {
+	quoted := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"$`\\*?;&|<>(){}") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted = append(quoted, arg)
 	}
+	return redact(strings.Join(quoted, " "))
+}
 
-	// Mount claude.json config file if it exists
-	claudeJSON := filepath.Join(os.Getenv("HOME"), ".claude.json")
-	if _, err := os.Stat(claudeJSON); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.claude.json:rw", claudeJSON))
-	}
+// printDryRun shows the podman command a session would run and discards the session.
+func printDryRun(args []string, session *Session) {
+	fmt.Println(quoteCommand("podman", args))
 
-	// Mount git config
-	gitConfig := filepath.Join(os.Getenv("HOME"), ".gitconfig")
-	if _, err := os.Stat(gitConfig); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.gitconfig:ro", gitConfig))
-	}
+	session.runCleanups()
+	os.RemoveAll(session.Dir())
+}
 
-	// Mount git credentials
-	gitCreds := filepath.Join(os.Getenv("HOME"), ".git-credentials")
-	if _, err := os.Stat(gitCreds); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.git-credentials:ro", gitCreds))
+func runShell() {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+		os.Exit(1)
 	}
 
-	// Mount SSH keys for git
-	sshDir := filepath.Join(os.Getenv("HOME"), ".ssh")
-	if _, err := os.Stat(sshDir); err == nil {
-		args = append(args, "-v", fmt.Sprintf("%s:/root/.ssh:ro", sshDir))
-	}
+	// A cloned repo must not get host access without the user agreeing to it
+	cwd, _ := os.Getwd()
+	requireTrust(config, cwd)
 
-	// Add privileged mode if requested
-	if config.Project.Privileged {
-		args = append(args, "--privileged", "--security-opt", "label=disable")
-		// Mount docker socket if it exists
-		if _, err := os.Stat("/var/run/docker.sock"); err == nil {
-			args = append(args, "-v", "/var/run/docker.sock:/var/run/docker.sock")
+	// Build project-specific image
+	if !dryRun {
+		if err := buildProjectImage(config); err != nil {
+			log.Fatal("\033[31m✗\033[0m Failed to build image:", err)
 		}
 	}
 
-	// Add volumes
-	for _, vol := range config.Volumes {
-		if vol.Source != "" && vol.Target != "" {
-			source := expandPath(vol.Source)
-			args = append(args, "-v", fmt.Sprintf("%s:%s:Z", source, vol.Target))
-		}
+	containerName := fmt.Sprintf("viber00t-shell-%s", filepath.Base(cwd))
+	globalConfig, _ := loadGlobalConfig()
+
+	session, err := startSession(config, globalConfig, "shell", cwd)
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to start session:", err)
 	}
 
-	// Add ports
-	for _, port := range config.Ports {
-		if port.Host != 0 && port.Container != 0 {
-			args = append(args, "-p", fmt.Sprintf("%d:%d", port.Host, port.Container))
-		}
+	// Check if container already exists
+	if !dryRun {
+		removeContainer(containerName)
 	}
 
-	// Environment variables
-	args = append(args, "-e", "TERM=xterm-256color")
-	args = append(args, "-e", "VIBER00T_PROJECT="+config.Project.Name)
-	args = append(args, "-e", "IS_SANDBOX=true")
+	args, err := containerArgs(config, globalConfig, session, cwd, containerName)
+	if err != nil {
+		session.finish(-1)
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
 
 	// Use project-specific image
 	imageName := getProjectImageName(config)
 	args = append(args, imageName)
 
 	// Override with bash
-	args = append(args, "/bin/bash")
+	args = append(args, initCommand(config, globalConfig, []string{"/bin/bash"})...)
+
+	session.Container = containerName
+	session.Image = imageName
+	session.Command = []string{"/bin/bash"}
+
+	if dryRun {
+		printDryRun(args, session)
+		return
+	}
 
 	fmt.Printf("\033[35m◉\033[0m Starting shell for \033[36m%s\033[0m...\n", config.Project.Name)
 	fmt.Println("\033[90m───────────────────────────────────\033[0m")
 
 	cmd := exec.Command("podman", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	runErr := runAttached(cmd, config, globalConfig, session)
+	runHostHooks("post_run", config.Hooks.PostRun, session,
+		hostHookEnv(config, session, fmt.Sprintf("VIBER00T_EXIT_CODE=%d", exitCode(runErr))))
+	session.finish(exitCode(runErr))
 
-	if err := cmd.Run(); err != nil {
-		log.Fatal("\033[31m✗\033[0m Shell failed:", err)
+	if runErr != nil {
+		log.Fatal("\033[31m✗\033[0m Shell failed:", runErr)
 	}
 }
 
@@ -897,6 +1469,9 @@ func cleanImages(cleanAll bool) {
 			fmt.Printf("\033[33m⚠\033[0m  Failed to clean state: %v\n", err)
 		}
 
+		// Shared networks nothing uses anymore
+		exec.Command("podman", "network", "prune", "-f", "--filter", "label=viber00t=owned").Run()
+
 		fmt.Println("\033[32m✓\033[0m All viber00t images and cache cleaned!")
 	} else {
 		// Load config to get project name
@@ -938,7 +1513,19 @@ func cleanImages(cleanAll bool) {
 	}
 }
 
+// confirm asks a yes/no question on the terminal, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func expandPath(path string) string {
+	if path == "~" {
+		return os.Getenv("HOME")
+	}
 	if strings.HasPrefix(path, "~/") {
 		home := os.Getenv("HOME")
 		return filepath.Join(home, path[2:])
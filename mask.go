@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaskPolicy is the [mask] section: project files the container must not read.
+type MaskPolicy struct {
+	Patterns []string // gitignore-style patterns, added to the built-in set
+	Defaults *bool    // use the built-in patterns (default true)
+	Except   []string // patterns that are never masked, e.g. ".env.example"
+}
+
+// Built-in patterns for local secrets commonly found in working trees
+var defaultMaskPatterns = []string{
+	".env", ".env.*", ".envrc",
+	"*.pem", "*.key", "*.p12", "*.pfx", "*.jks", "*.keystore",
+	"id_rsa*", "id_ecdsa*", "id_ed25519*",
+	"*.tfstate", "*.tfstate.*", ".terraform/",
+	".npmrc", ".pypirc", ".netrc", ".git-credentials",
+	".aws/", ".kube/", ".docker/",
+}
+
+// maskedPath is a project path shadowed inside the container.
+type maskedPath struct {
+	Rel   string
+	IsDir bool
+}
+
+// resolveMaskPolicy merges the project [mask] section over the global one.
+func resolveMaskPolicy(config *Config, globalConfig *GlobalConfig) MaskPolicy {
+	policy := MaskPolicy{
+		Patterns: append(append([]string(nil), globalConfig.Mask.Patterns...), config.Mask.Patterns...),
+		Defaults: globalConfig.Mask.Defaults,
+		Except:   append(append([]string(nil), globalConfig.Mask.Except...), config.Mask.Except...),
+	}
+	if config.Mask.Defaults != nil {
+		policy.Defaults = config.Mask.Defaults
+	}
+	return policy
+}
+
+func (p MaskPolicy) patterns() []string {
+	if p.Defaults == nil || *p.Defaults {
+		return append(append([]string(nil), defaultMaskPatterns...), p.Patterns...)
+	}
+	return p.Patterns
+}
+
+// findMasked walks the whole project, ignored files included since that is
+// where local secrets live, and returns every path to shadow.
+func findMasked(policy MaskPolicy, dir string) []maskedPath {
+	patterns := policy.patterns()
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	var masked []maskedPath
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() && (info.Name() == ".git" || info.Name() == "node_modules") {
+			return filepath.SkipDir
+		}
+		if !gitignoreMatch(patterns, rel, info.IsDir()) || gitignoreMatch(policy.Except, rel, info.IsDir()) {
+			return nil
+		}
+
+		masked = append(masked, maskedPath{Rel: rel, IsDir: info.IsDir()})
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return masked
+}
+
+// maskArgs shadows files with /dev/null and directories with an empty tmpfs.
+func maskArgs(masked []maskedPath, target string) []string {
+	var args []string
+	for _, path := range masked {
+		containerPath := target + "/" + path.Rel
+		if path.IsDir {
+			args = append(args, "--tmpfs", containerPath+":ro,size=4k")
+		} else {
+			args = append(args, "-v", "/dev/null:"+containerPath+":ro")
+		}
+	}
+	return args
+}
+
+func printMasked(masked []maskedPath) {
+	if len(masked) == 0 {
+		return
+	}
+	const shown = 8
+	var names []string
+	for i, path := range masked {
+		if i == shown {
+			names = append(names, fmt.Sprintf("and %d more", len(masked)-shown))
+			break
+		}
+		name := path.Rel
+		if path.IsDir {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	fmt.Printf("\033[33m⚠\033[0m  Masked from the container: %s\n", strings.Join(names, ", "))
+}
+
+// describe summarizes [mask] settings that expose more than the defaults.
+func (p MaskPolicy) describe() string {
+	var parts []string
+	if p.Defaults != nil && !*p.Defaults {
+		parts = append(parts, "defaults = false")
+	}
+	if len(p.Except) > 0 {
+		parts = append(parts, "except = ["+strings.Join(p.Except, ", ")+"]")
+	}
+	return joinSettings(parts...)
+}
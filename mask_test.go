@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindMasked(t *testing.T) {
+	off := false
+	files := map[string]string{
+		".env":                "TOKEN=x",
+		".env.example":        "TOKEN=",
+		"config/prod.pem":     "key",
+		"config/app.yaml":     "a: b",
+		".aws/credentials":    "key",
+		"deploy/.aws/config":  "key",
+		"infra/state.tfstate": "{}",
+		"secrets/api.txt":     "key",
+		"node_modules/x/.env": "ignored",
+		".git/.env":           "not a project file",
+		"main.go":             "package main",
+	}
+	tests := []struct {
+		name   string
+		policy MaskPolicy
+		want   []maskedPath
+	}{
+		{
+			name:   "defaults",
+			policy: MaskPolicy{},
+			want: []maskedPath{
+				{".aws", true}, {".env", false}, {".env.example", false},
+				{"config/prod.pem", false}, {"deploy/.aws", true}, {"infra/state.tfstate", false},
+			},
+		},
+		{
+			name:   "except",
+			policy: MaskPolicy{Except: []string{".env.example", "/config/prod.pem"}},
+			want: []maskedPath{
+				{".aws", true}, {".env", false}, {"deploy/.aws", true}, {"infra/state.tfstate", false},
+			},
+		},
+		{
+			name:   "extra patterns",
+			policy: MaskPolicy{Patterns: []string{"secrets/"}, Except: []string{".env*"}},
+			want: []maskedPath{
+				{".aws", true}, {"config/prod.pem", false}, {"deploy/.aws", true},
+				{"infra/state.tfstate", false}, {"secrets", true},
+			},
+		},
+		{
+			name:   "without defaults",
+			policy: MaskPolicy{Defaults: &off, Patterns: []string{"/secrets/api.txt"}},
+			want:   []maskedPath{{"secrets/api.txt", false}},
+		},
+		{
+			name:   "nothing to mask",
+			policy: MaskPolicy{Defaults: &off},
+		},
+	}
+
+	dir := t.TempDir()
+	writeTree(t, dir, files)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findMasked(tt.policy, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("masked %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveMaskPolicy(t *testing.T) {
+	off, on := false, true
+	globalConfig := &GlobalConfig{}
+	globalConfig.Mask = MaskPolicy{Patterns: []string{"*.secret"}, Defaults: &off, Except: []string{"a"}}
+	config := &Config{}
+	config.Mask = MaskPolicy{Patterns: []string{"local/"}, Defaults: &on, Except: []string{"b"}}
+
+	policy := resolveMaskPolicy(config, globalConfig)
+	if want := []string{"*.secret", "local/"}; !reflect.DeepEqual(policy.Patterns, want) {
+		t.Errorf("patterns %v, want %v", policy.Patterns, want)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(policy.Except, want) {
+		t.Errorf("except %v, want %v", policy.Except, want)
+	}
+	if policy.Defaults == nil || !*policy.Defaults {
+		t.Errorf("project defaults = true did not win over the global config")
+	}
+	if len(policy.patterns()) != len(defaultMaskPatterns)+2 {
+		t.Errorf("patterns() has %d entries, want the defaults plus 2", len(policy.patterns()))
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount is a single bind mount into the container, along with why it is there.
+type Mount struct {
+	Name    string
+	Source  string
+	Target  string
+	Mode    string // "rw", "ro" or "off"
+	Options string // extra mount options such as "Z"
+	Reason  string
+}
+
+// Built-in home directory mounts, controlled by the [mounts] policy
+var builtinMounts = []struct {
+	Name    string
+	Source  string
+	Target  string
+	Default string
+}{
+	{"claude", "~/.claude", "/root/.claude", "rw"},
+	{"claude_json", "~/.claude.json", "/root/.claude.json", "rw"},
+	{"gitconfig", "~/.gitconfig", "/root/.gitconfig", "ro"},
+	// Replaced by the git credential bridge and SSH agent forwarding
+	{"git_credentials", "~/.git-credentials", "/root/.git-credentials", "off"},
+	{"ssh", "~/.ssh", "/root/.ssh", "off"},
+}
+
+func validMountMode(mode string) bool {
+	return mode == "rw" || mode == "ro" || mode == "off"
+}
+
+// checkMountPolicy rejects unknown mount names and modes so a typo can't
+// silently leave a credential exposed.
+func checkMountPolicy(policy map[string]string, origin string) error {
+	for name, mode := range policy {
+		known := false
+		for _, builtin := range builtinMounts {
+			if builtin.Name == name {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("%s: unknown mount %q in [mounts]", origin, name)
+		}
+		if !validMountMode(mode) {
+			return fmt.Errorf("%s: mount %q must be \"rw\", \"ro\" or \"off\", got %q", origin, name, mode)
+		}
+	}
+	return nil
+}
+
+// resolveMounts works out every host path the container will see. Built-in
+// mounts take their mode from the project config, then the global config,
+// then the built-in default; disabled and missing ones are kept with Mode
+// "off" so they can be explained. sessionDir is empty when only describing.
+func resolveMounts(config *Config, globalConfig *GlobalConfig, cwd, sessionDir string) []Mount {
+	mounts := []Mount{{
+		Name:   "project",
+		Source: cwd,
+		Target: "/c0de/" + config.Project.Name,
+		Mode:   "rw",
+		Reason: "project directory",
+	}}
+
+	for _, builtin := range builtinMounts {
+		mount := Mount{
+			Name:   builtin.Name,
+			Source: expandPath(builtin.Source),
+			Target: builtin.Target,
+			Mode:   builtin.Default,
+			Reason: "default",
+		}
+		if mode, ok := globalConfig.Mounts[builtin.Name]; ok {
+			mount.Mode, mount.Reason = mode, "global config"
+		}
+		if mode, ok := config.Mounts[builtin.Name]; ok {
+			mount.Mode, mount.Reason = mode, "Viber00t.toml"
+		}
+		if mount.Mode == "off" {
+			mount.Reason = "disabled by " + mount.Reason
+		} else {
+			if _, err := os.Stat(mount.Source); err != nil {
+				mount.Mode, mount.Reason = "off", "not present on host"
+			}
+		}
+		mounts = append(mounts, mount)
+	}
+
+	// SSH agent socket and ssh client files
+	sshDirMounted := false
+	for _, mount := range mounts {
+		if mount.Name == "ssh" {
+			sshDirMounted = mount.Mode != "off"
+		}
+	}
+	mounts = append(mounts, sshMounts(resolveSSHPolicy(config, globalConfig), sshDirMounted, sessionDir)...)
+
+	// The viber00t binary and the git credential bridge socket
+	mounts = append(mounts, toolMount())
+	mounts = append(mounts, credentialMounts(resolveCredentialPolicy(config, globalConfig), sessionDir)...)
+	mounts = append(mounts, gpgMounts(resolveGPGPolicy(config, globalConfig), sessionDir)...)
+	mounts = append(mounts, networkMounts(resolveNetworkPolicy(config, globalConfig), sessionDir)...)
+	mounts = append(mounts, auditMounts(auditEnabled(config, globalConfig), sessionDir)...)
+	mounts = append(mounts, forwardMounts(resolveForwardPolicy(config, globalConfig), sessionDir)...)
+	mounts = append(mounts, hooksMounts(config.Hooks, sessionDir)...)
+
+	// Privileged mode exposes the docker socket
+	if resolveSecurity(config, globalConfig).Privileged {
+		if _, err := os.Stat("/var/run/docker.sock"); err == nil {
+			mounts = append(mounts, Mount{
+				Name:   "docker",
+				Source: "/var/run/docker.sock",
+				Target: "/var/run/docker.sock",
+				Mode:   "rw",
+				Reason: "privileged security profile",
+			})
+		}
+	}
+
+	for _, vol := range config.Volumes {
+		if vol.Source != "" && vol.Target != "" {
+			mounts = append(mounts, Mount{
+				Name:    "volume",
+				Source:  expandPath(vol.Source),
+				Target:  vol.Target,
+				Mode:    "rw",
+				Options: "Z",
+				Reason:  "[[volumes]] in Viber00t.toml",
+			})
+		}
+	}
+
+	// Home mounts follow the container user
+	u := resolveUser(config, globalConfig)
+	for i := range mounts {
+		mounts[i].Target = u.retarget(mounts[i].Target)
+	}
+	return mounts
+}
+
+func mountArgs(mounts []Mount) []string {
+	var args []string
+	for _, mount := range mounts {
+		if mount.Mode == "off" {
+			continue
+		}
+		var opts []string
+		if mount.Name != "project" && mount.Name != "docker" && mount.Name != "volume" {
+			opts = append(opts, mount.Mode)
+		} else if mount.Mode == "ro" {
+			opts = append(opts, "ro")
+		}
+		if mount.Options != "" {
+			opts = append(opts, mount.Options)
+		}
+		spec := mount.Source + ":" + mount.Target
+		if len(opts) > 0 {
+			spec += ":" + strings.Join(opts, ",")
+		}
+		args = append(args, "-v", spec)
+	}
+	return args
+}
+
+func showMounts() {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+		os.Exit(1)
+	}
+	globalConfig, _ := loadGlobalConfig()
+	if err := checkMountPolicy(globalConfig.Mounts, "global config"); err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	if err := checkMountPolicy(config.Mounts, "Viber00t.toml"); err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+
+	cwd, _ := os.Getwd()
+	mounts := resolveMounts(config, globalConfig, cwd, "")
+
+	fmt.Printf("\033[35m◉\033[0m Mounts for \033[36m%s\033[0m\n", config.Project.Name)
+	var hidden []Mount
+	for _, mount := range mounts {
+		if mount.Mode == "off" {
+			hidden = append(hidden, mount)
+			continue
+		}
+		fmt.Printf("  \033[32m%-3s\033[0m %-28s → %-28s \033[90m%s\033[0m\n",
+			mount.Mode, shortenHome(mount.Source), mount.Target, mount.Reason)
+	}
+
+	if masked := findMasked(resolveMaskPolicy(config, globalConfig), cwd); len(masked) > 0 {
+		fmt.Println("\033[33mMasked:\033[0m")
+		for _, path := range masked {
+			kind := "empty file"
+			if path.IsDir {
+				kind = "empty directory"
+			}
+			fmt.Printf("  \033[33m%-3s\033[0m %-28s → %-28s \033[90m%s\033[0m\n",
+				"ro", kind, "/c0de/"+config.Project.Name+"/"+path.Rel, "[mask]")
+		}
+	}
+
+	if len(hidden) > 0 {
+		fmt.Println("\033[33mNot mounted:\033[0m")
+		for _, mount := range hidden {
+			fmt.Printf("  \033[90moff %-28s   %-28s %s\033[0m\n", shortenHome(mount.Source), "", mount.Reason)
+		}
+	}
+}
+
+func shortenHome(path string) string {
+	home := os.Getenv("HOME")
+	if home != "" && strings.HasPrefix(path, home+string(filepath.Separator)) {
+		return "~" + path[len(home):]
+	}
+	return path
+}
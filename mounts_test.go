@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestCheckMountPolicy(t *testing.T) {
+	tests := []struct {
+		policy  map[string]string
+		wantErr string
+	}{
+		{map[string]string{"ssh": "off", "gitconfig": "ro", "claude": "rw"}, ""},
+		{map[string]string{"sshh": "off"}, "unknown mount"},
+		{map[string]string{"ssh": "no"}, "must be"},
+	}
+	for _, tt := range tests {
+		err := checkMountPolicy(tt.policy, "test")
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%v: unexpected error: %v", tt.policy, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%v: err = %v, want %q", tt.policy, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveMounts(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeTree(t, home, map[string]string{".gitconfig": "", ".ssh/config": "", ".claude.json": "{}"})
+
+	var config Config
+	_, err := toml.Decode(`
+[project]
+name = "demo"
+
+[mounts]
+claude_json = "ro"
+
+[[volumes]]
+source = "~/data"
+target = "/data"
+`, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globalConfig := &GlobalConfig{Mounts: map[string]string{"ssh": "ro", "claude_json": "off"}}
+
+	mounts := map[string]Mount{}
+	for _, mount := range resolveMounts(&config, globalConfig, "/work/demo", "/state/session") {
+		mounts[mount.Name] = mount
+	}
+	tests := []struct {
+		name, mode, reason string
+	}{
+		{"project", "rw", "project directory"},
+		{"claude", "off", "not present on host"},
+		{"claude_json", "ro", "Viber00t.toml"},
+		{"gitconfig", "ro", "default"},
+		{"ssh", "ro", "global config"},
+		{"volume", "rw", "[[volumes]] in Viber00t.toml"},
+	}
+	for _, tt := range tests {
+		mount, ok := mounts[tt.name]
+		if !ok {
+			t.Errorf("no %s mount", tt.name)
+			continue
+		}
+		if mount.Mode != tt.mode || mount.Reason != tt.reason {
+			t.Errorf("%s: %s (%s), want %s (%s)", tt.name, mount.Mode, mount.Reason, tt.mode, tt.reason)
+		}
+	}
+	if got, want := mounts["volume"].Source, filepath.Join(home, "data"); got != want {
+		t.Errorf("volume source %s, want %s", got, want)
+	}
+	if got := mounts["project"].Target; got != "/c0de/demo" {
+		t.Errorf("project target %s", got)
+	}
+
+	if _, ok := mounts["ssh_config"]; ok {
+		t.Errorf("ssh config copied in although ~/.ssh is mounted")
+	}
+
+	// Turning a mount off in the project wins over the global config, and
+	// without ~/.ssh its config is copied in instead
+	config.Mounts["ssh"] = "off"
+	mounts = map[string]Mount{}
+	for _, mount := range resolveMounts(&config, globalConfig, "/work/demo", "/state/session") {
+		mounts[mount.Name] = mount
+	}
+	if mount := mounts["ssh"]; mount.Mode != "off" || mount.Reason != "disabled by Viber00t.toml" {
+		t.Errorf("ssh: %s (%s), want off", mount.Mode, mount.Reason)
+	}
+	if mount := mounts["ssh_config"]; mount.Source != "/state/session/ssh/config" || mount.Mode != "ro" {
+		t.Errorf("ssh config: %+v", mount)
+	}
+	if _, ok := mounts["ssh_known_hosts"]; ok {
+		t.Errorf("missing known_hosts copied in")
+	}
+}
+
+func TestMountArgs(t *testing.T) {
+	mounts := []Mount{
+		{Name: "project", Source: "/work/demo", Target: "/c0de/demo", Mode: "rw"},
+		{Name: "claude", Source: "/home/me/.claude", Target: "/root/.claude", Mode: "off"},
+		{Name: "gitconfig", Source: "/home/me/.gitconfig", Target: "/root/.gitconfig", Mode: "ro"},
+		{Name: "volume", Source: "/home/me/data", Target: "/data", Mode: "rw", Options: "Z"},
+	}
+	want := []string{
+		"-v", "/work/demo:/c0de/demo",
+		"-v", "/home/me/.gitconfig:/root/.gitconfig:ro",
+		"-v", "/home/me/data:/data:Z",
+	}
+	if got := mountArgs(mounts); !reflect.DeepEqual(got, want) {
+		t.Errorf("args %q, want %q", got, want)
+	}
+}
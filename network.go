@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetworkPolicy controls what the container can reach.
+type NetworkPolicy struct {
+	Mode       string            // "full" (default), "none" or "allowlist"
+	Allow      []string          // hosts reachable in allowlist mode, globs allowed
+	Networks   []string          // named networks shared with other projects, created on demand
+	Aliases    []string          // names the container answers to on those networks
+	DNS        []string          `toml:"dns"` // DNS servers
+	Hosts      map[string]string // extra /etc/hosts entries, name = "ip"
+	HostAccess *bool             `toml:"host_access"` // host.containers.internal, default true
+}
+
+var validNetworkName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+const (
+	containerEgressSock = "/run/viber00t/egress.sock"
+	containerProxyAddr  = "127.0.0.1:3128"
+)
+
+// resolveNetworkPolicy merges the project [network] section over the global one.
+// The agent's own API hosts are always allowed so an allowlist can't lock it out.
+func resolveNetworkPolicy(config *Config, globalConfig *GlobalConfig) NetworkPolicy {
+	policy := globalConfig.Network
+	if config.Network.Mode != "" {
+		policy.Mode = config.Network.Mode
+	}
+	if policy.Mode == "" {
+		policy.Mode = "full"
+	}
+	policy.Allow = append([]string(nil), policy.Allow...)
+	policy.Allow = append(policy.Allow, config.Network.Allow...)
+	policy.Networks = append(append([]string(nil), policy.Networks...), config.Network.Networks...)
+	policy.Aliases = append(append([]string(nil), policy.Aliases...), config.Network.Aliases...)
+	policy.DNS = append(append([]string(nil), policy.DNS...), config.Network.DNS...)
+	hosts := make(map[string]string)
+	for _, layer := range []map[string]string{policy.Hosts, config.Network.Hosts} {
+		for name, ip := range layer {
+			hosts[name] = ip
+		}
+	}
+	policy.Hosts = hosts
+	if config.Network.HostAccess != nil {
+		policy.HostAccess = config.Network.HostAccess
+	}
+	if config.Project.Agent != "" {
+		policy.Allow = append(policy.Allow, getAgentDefinition(config.Project.Agent, globalConfig).Hosts...)
+	}
+	return policy
+}
+
+func checkNetworkPolicy(policy NetworkPolicy, origin string) error {
+	switch policy.Mode {
+	case "", "full", "none", "allowlist":
+	default:
+		return fmt.Errorf("%s: network mode must be \"full\", \"none\" or \"allowlist\", got %q", origin, policy.Mode)
+	}
+	for _, name := range policy.Networks {
+		if !validNetworkName.MatchString(name) {
+			return fmt.Errorf("%s: invalid network name %q", origin, name)
+		}
+	}
+	for _, alias := range policy.Aliases {
+		if hostLabel(alias) != strings.ToLower(alias) {
+			return fmt.Errorf("%s: invalid network alias %q", origin, alias)
+		}
+	}
+	for _, server := range policy.DNS {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("%s: dns server %q is not an IP address", origin, server)
+		}
+	}
+	for name, ip := range policy.Hosts {
+		if net.ParseIP(ip) == nil && ip != "host-gateway" {
+			return fmt.Errorf("%s: hosts entry %s = %q is not an IP address", origin, name, ip)
+		}
+	}
+	return nil
+}
+
+func (p NetworkPolicy) hostAccess() bool {
+	return p.HostAccess == nil || *p.HostAccess
+}
+
+// describe summarizes the project's [network] section for the trust prompt.
+func (p NetworkPolicy) describe() string {
+	var parts []string
+	if p.Mode != "" {
+		parts = append(parts, "mode = "+p.Mode)
+	}
+	if len(p.Allow) > 0 {
+		parts = append(parts, "allow = ["+strings.Join(p.Allow, ", ")+"]")
+	}
+	if len(p.Networks) > 0 {
+		parts = append(parts, "networks = ["+strings.Join(p.Networks, ", ")+"]")
+	}
+	if len(p.Aliases) > 0 {
+		parts = append(parts, "aliases = ["+strings.Join(p.Aliases, ", ")+"]")
+	}
+	if len(p.DNS) > 0 {
+		parts = append(parts, "dns = ["+strings.Join(p.DNS, ", ")+"]")
+	}
+	for _, name := range sortedKeys(p.Hosts) {
+		parts = append(parts, fmt.Sprintf("hosts.%s = %s", name, p.Hosts[name]))
+	}
+	parts = append(parts, describeBool("host_access", p.HostAccess))
+	return joinSettings(parts...)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ensureNetwork creates a podman network unless it exists, labeled so
+// viber00t can tell its own networks apart.
+func ensureNetwork(name string, internal bool, labels ...string) error {
+	if exec.Command("podman", "network", "exists", name).Run() == nil {
+		return nil
+	}
+	create := []string{"network", "create", "--label", "viber00t=owned"}
+	for _, label := range labels {
+		create = append(create, "--label", label)
+	}
+	if internal {
+		create = append(create, "--internal")
+	}
+	if output, err := exec.Command("podman", append(create, name)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create network %s: %s", name, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (p NetworkPolicy) allows(host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range p.Allow {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// networkMounts describes the egress proxy socket. sessionDir is empty when only describing.
+func networkMounts(policy NetworkPolicy, sessionDir string) []Mount {
+	if policy.Mode != "allowlist" {
+		return nil
+	}
+	if sessionDir == "" {
+		sessionDir = "<session>"
+	}
+	reason := "egress proxy, nothing allowed"
+	if len(policy.Allow) > 0 {
+		reason = "egress proxy for " + strings.Join(policy.Allow, ", ")
+	}
+	return []Mount{{
+		Name:   "egress_proxy",
+		Source: filepath.Join(sessionDir, "egress.sock"),
+		Target: containerEgressSock,
+		Mode:   "rw",
+		Reason: reason,
+	}}
+}
+
+// networkArgs isolates the container and, for allowlists, starts the host
+// side of the egress proxy. The container only has loopback, or the internal
+// services network when given; the in-container relay (see containerInit)
+// hands proxy connections to the host over a socket.
+func networkArgs(policy NetworkPolicy, network string, config *Config, session *Session) ([]string, error) {
+	isolated := []string{"--network", "none"}
+	if network != "" {
+		isolated = []string{"--network", network}
+	}
+	isolated = append(isolated, hostsArgs(policy)...)
+	if policy.Mode != "full" && len(policy.Networks) > 0 {
+		return nil, fmt.Errorf("joining networks needs network mode \"full\", not %q", policy.Mode)
+	}
+
+	switch policy.Mode {
+	case "none":
+		return isolated, nil
+	case "allowlist":
+		if !dryRun {
+			proxy := &egressProxy{policy: policy, logPath: filepath.Join(session.Dir(), "egress.log")}
+			if err := proxy.listen(filepath.Join(session.Dir(), "egress.sock"), session); err != nil {
+				return nil, err
+			}
+		}
+		proxyURL := "http://" + containerProxyAddr
+		return append(isolated,
+			"-e", "HTTP_PROXY="+proxyURL, "-e", "http_proxy="+proxyURL,
+			"-e", "HTTPS_PROXY="+proxyURL, "-e", "https_proxy="+proxyURL,
+			"-e", "NO_PROXY=localhost,127.0.0.1", "-e", "no_proxy=localhost,127.0.0.1",
+		), nil
+	}
+
+	// Full access, on the services network and any shared ones
+	var args []string
+	networks := policy.Networks
+	if network != "" {
+		networks = append([]string{network}, networks...)
+	}
+	for _, name := range networks {
+		if !dryRun && name != network {
+			if err := ensureNetwork(name, false); err != nil {
+				return nil, err
+			}
+		}
+		args = append(args, "--network", name)
+	}
+	if len(policy.Networks) > 0 {
+		// Other projects reach this one by its name
+		aliases := append([]string{hostLabel(config.Project.Name)}, policy.Aliases...)
+		for _, alias := range aliases {
+			args = append(args, "--network-alias", alias)
+		}
+	}
+	for _, server := range policy.DNS {
+		args = append(args, "--dns", server)
+	}
+	return append(args, hostsArgs(policy)...), nil
+}
+
+// hostsArgs adds the extra /etc/hosts entries. Without host access,
+// host.containers.internal points back at the container itself.
+func hostsArgs(policy NetworkPolicy) []string {
+	var args []string
+	for _, name := range sortedKeys(policy.Hosts) {
+		args = append(args, "--add-host", name+":"+policy.Hosts[name])
+	}
+	if !policy.hostAccess() {
+		args = append(args, "--add-host", "host.containers.internal:127.0.0.1", "--add-host", "host.docker.internal:127.0.0.1")
+	}
+	return args
+}
+
+// egressProxy is an HTTP proxy that only connects to allowed hosts. It
+// resolves names itself, so the container never needs DNS.
+type egressProxy struct {
+	policy  NetworkPolicy
+	logPath string
+
+	mu sync.Mutex
+}
+
+func (p *egressProxy) listen(sock string, session *Session) error {
+	os.Remove(sock)
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return fmt.Errorf("failed to start egress proxy: %w", err)
+	}
+	os.Chmod(sock, 0600)
+	session.onFinish(func() {
+		listener.Close()
+		os.Remove(sock)
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return nil
+}
+
+func (p *egressProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+
+	target := req.Host
+	if req.Method != http.MethodConnect && req.URL.Host != "" {
+		target = req.URL.Host
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = target, "80"
+	}
+
+	if !p.policy.allows(host) {
+		p.log(req.Method, net.JoinHostPort(host, port), "denied")
+		fmt.Fprintf(conn, "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nviber00t: %s is not in the network allowlist\n", host)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 30*time.Second)
+	if err != nil {
+		p.log(req.Method, net.JoinHostPort(host, port), "failed: "+err.Error())
+		fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+		return
+	}
+	defer upstream.Close()
+	p.log(req.Method, net.JoinHostPort(host, port), "allowed")
+
+	if req.Method == http.MethodConnect {
+		fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	} else {
+		// One request per connection keeps every request going through the check
+		req.Close = true
+		req.Header.Del("Proxy-Connection")
+		if err := req.Write(upstream); err != nil {
+			return
+		}
+	}
+
+	go func() {
+		io.Copy(upstream, reader)
+		if tcp, ok := upstream.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+	}()
+	io.Copy(conn, upstream)
+}
+
+func (p *egressProxy) log(method, target, verdict string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.OpenFile(p.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s %s %s: %s\n", time.Now().Format(time.RFC3339), method, target, verdict)
+}
+
+// egressRelay is the container end: a loopback listener that forwards every
+// connection to the host proxy socket.
+func egressRelay(sock string) error {
+	listener, err := net.Listen("tcp", containerProxyAddr)
+	if err != nil {
+		return fmt.Errorf("egress relay: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				upstream, err := net.Dial("unix", sock)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go func() {
+					io.Copy(upstream, conn)
+					upstream.(*net.UnixConn).CloseWrite()
+				}()
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNetworkPolicyAllows(t *testing.T) {
+	policy := NetworkPolicy{Mode: "allowlist", Allow: []string{"github.com", "*.example.com", "127.0.0.1"}}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"github.com", true},
+		{"GitHub.com", true},
+		{"api.github.com", false},
+		{"api.example.com", true},
+		{"example.com", false},
+		{"example.com.evil.com", false},
+		{"127.0.0.1", true},
+		// Names are checked as given, never resolved first
+		{"localhost", false},
+	}
+	for _, tt := range tests {
+		if got := policy.allows(tt.host); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+// startEgressProxy runs the host side of the egress proxy on a socket in a
+// temporary session directory.
+func startEgressProxy(t *testing.T, allow ...string) (sock, logPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	sock = filepath.Join(dir, "egress.sock")
+	logPath = filepath.Join(dir, "egress.log")
+	proxy := &egressProxy{policy: NetworkPolicy{Mode: "allowlist", Allow: allow}, logPath: logPath}
+	session := &Session{}
+	if err := proxy.listen(sock, session); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(session.runCleanups)
+	return sock, logPath
+}
+
+// readHead reads a response status line and headers.
+func readHead(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading response: %v (got %q)", err, head.String())
+		}
+		if line == "\r\n" {
+			return head.String()
+		}
+		head.WriteString(line)
+	}
+}
+
+func TestEgressProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "hello from %s", req.URL.Path)
+		if value := req.Header.Get("Proxy-Connection"); value != "" {
+			fmt.Fprintf(w, " (Proxy-Connection: %s)", value)
+		}
+	}))
+	defer upstream.Close()
+	target := upstream.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(target)
+
+	sock, logPath := startEgressProxy(t, "127.0.0.1")
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, bufio.NewReader(conn)
+	}
+
+	t.Run("allowed CONNECT", func(t *testing.T) {
+		conn, reader := dial()
+		defer conn.Close()
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+		if head := readHead(t, reader); !strings.HasPrefix(head, "HTTP/1.1 200") {
+			t.Fatalf("CONNECT answered %q", head)
+		}
+		// The tunnel reaches the upstream as is
+		fmt.Fprintf(conn, "GET /tunnel HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", target)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != "hello from /tunnel" {
+			t.Errorf("tunneled response %q", body)
+		}
+	})
+
+	t.Run("denied CONNECT", func(t *testing.T) {
+		conn, reader := dial()
+		defer conn.Close()
+		fmt.Fprintf(conn, "CONNECT 10.0.0.1:443 HTTP/1.1\r\nHost: 10.0.0.1:443\r\n\r\n")
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "10.0.0.1 is not in the network allowlist") {
+			t.Errorf("got %d %q, want 403", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("plain HTTP", func(t *testing.T) {
+		conn, reader := dial()
+		defer conn.Close()
+		fmt.Fprintf(conn, "GET http://%s/plain HTTP/1.1\r\nHost: %s\r\nProxy-Connection: keep-alive\r\n\r\n", target, target)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "hello from /plain" {
+			t.Errorf("got %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("hostname not in the allowlist", func(t *testing.T) {
+		// Resolves to an allowed address, but only the name is checked
+		conn, reader := dial()
+		defer conn.Close()
+		fmt.Fprintf(conn, "GET http://localhost:%s/ HTTP/1.1\r\nHost: localhost:%s\r\n\r\n", port, port)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("got %d, want 403", resp.StatusCode)
+		}
+	})
+
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"CONNECT " + target + ": allowed",
+		"CONNECT 10.0.0.1:443: denied",
+		"GET " + target + ": allowed",
+		"GET localhost:" + port + ": denied",
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("log %q, want %d lines", data, len(want))
+	}
+	stamp := regexp.MustCompile(`^\d{4}-\d\d-\d\dT\S+ `)
+	for i, line := range lines {
+		if !stamp.MatchString(line) || !strings.HasSuffix(line, " "+want[i]) {
+			t.Errorf("log line %q, want a timestamp and %q", line, want[i])
+		}
+	}
+}
+
+func TestEgressProxyCleanup(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "egress.sock")
+	session := &Session{}
+	proxy := &egressProxy{logPath: filepath.Join(dir, "egress.log")}
+	if err := proxy.listen(sock, session); err != nil {
+		t.Fatal(err)
+	}
+	session.runCleanups()
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket left behind: %v", err)
+	}
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		t.Errorf("proxy still accepting after the session ended")
+	}
+}
+
+func TestCheckNetworkPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  NetworkPolicy
+		wantErr string
+	}{
+		{"empty", NetworkPolicy{}, ""},
+		{"valid", NetworkPolicy{Mode: "full", Networks: []string{"shared_db.1"}, Aliases: []string{"api"}, DNS: []string{"1.1.1.1", "::1"}, Hosts: map[string]string{"db": "10.0.0.5", "host": "host-gateway"}}, ""},
+		{"mode", NetworkPolicy{Mode: "open"}, "network mode"},
+		{"network name", NetworkPolicy{Networks: []string{"-x"}}, "invalid network name"},
+		{"alias", NetworkPolicy{Aliases: []string{"My_API"}}, "invalid network alias"},
+		{"dns", NetworkPolicy{DNS: []string{"dns.google"}}, "not an IP address"},
+		{"hosts", NetworkPolicy{Hosts: map[string]string{"db": "db.internal"}}, "not an IP address"},
+	}
+	for _, tt := range tests {
+		err := checkNetworkPolicy(tt.policy, "test")
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveNetworkPolicy(t *testing.T) {
+	no := false
+	globalConfig := &GlobalConfig{}
+	globalConfig.Network = NetworkPolicy{
+		Allow:    []string{"proxy.corp"},
+		Networks: []string{"shared"},
+		DNS:      []string{"10.0.0.53"},
+		Hosts:    map[string]string{"db": "10.0.0.5", "cache": "10.0.0.6"},
+	}
+	config := decodeConfig(t, `
+[project]
+agent = "claude"
+
+[network]
+mode = "allowlist"
+allow = ["github.com"]
+aliases = ["api"]
+host_access = false
+
+[network.hosts]
+db = "10.0.0.9"
+`)
+	policy := resolveNetworkPolicy(config, globalConfig)
+	if policy.Mode != "allowlist" || policy.hostAccess() {
+		t.Errorf("mode %s, host access %v", policy.Mode, policy.hostAccess())
+	}
+	// The agent's API stays reachable
+	if !policy.allows("proxy.corp") || !policy.allows("github.com") || !policy.allows("api.anthropic.com") {
+		t.Errorf("allow %v", policy.Allow)
+	}
+	if want := map[string]string{"db": "10.0.0.9", "cache": "10.0.0.6"}; !reflect.DeepEqual(policy.Hosts, want) {
+		t.Errorf("hosts %v, want %v", policy.Hosts, want)
+	}
+	if !reflect.DeepEqual(policy.Networks, []string{"shared"}) || !reflect.DeepEqual(policy.Aliases, []string{"api"}) {
+		t.Errorf("networks %v, aliases %v", policy.Networks, policy.Aliases)
+	}
+	// Merging never touches the global config
+	if len(globalConfig.Network.Allow) != 1 || globalConfig.Network.Hosts["db"] != "10.0.0.5" {
+		t.Errorf("global config changed: %+v", globalConfig.Network)
+	}
+
+	globalConfig.Network.HostAccess = &no
+	if resolveNetworkPolicy(&Config{}, globalConfig).hostAccess() {
+		t.Errorf("global host_access = false ignored")
+	}
+}
+
+func TestNetworkArgs(t *testing.T) {
+	dryRun = true
+	defer func() { dryRun = false }()
+	no := false
+	config := &Config{}
+	config.Project.Name = "My App"
+
+	tests := []struct {
+		name    string
+		policy  NetworkPolicy
+		network string
+		want    []string
+		wantErr string
+	}{
+		{"full", NetworkPolicy{Mode: "full"}, "", nil, ""},
+		{"none", NetworkPolicy{Mode: "none"}, "", []string{"--network", "none"}, ""},
+		{"none with services", NetworkPolicy{Mode: "none"}, "viber00t-app", []string{"--network", "viber00t-app"}, ""},
+		{
+			"shared networks",
+			NetworkPolicy{Mode: "full", Networks: []string{"shared"}, Aliases: []string{"api"}, DNS: []string{"1.1.1.1"}},
+			"viber00t-app",
+			[]string{"--network", "viber00t-app", "--network", "shared", "--network-alias", "my-app", "--network-alias", "api", "--dns", "1.1.1.1"},
+			"",
+		},
+		{
+			"hosts",
+			NetworkPolicy{Mode: "full", Hosts: map[string]string{"b": "10.0.0.2", "a": "10.0.0.1"}, HostAccess: &no},
+			"",
+			[]string{
+				"--add-host", "a:10.0.0.1", "--add-host", "b:10.0.0.2",
+				"--add-host", "host.containers.internal:127.0.0.1", "--add-host", "host.docker.internal:127.0.0.1",
+			},
+			"",
+		},
+		{"shared networks need full access", NetworkPolicy{Mode: "none", Networks: []string{"shared"}}, "", nil, "needs network mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := networkArgs(tt.policy, tt.network, config, &Session{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("args %q, want %q", args, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PortMapping is a [[ports]] entry.
+type PortMapping struct {
+	Name      string   // service name for the proxy, <name>.<project>.localhost
+	Host      portSpec // number, "start-end" or "auto" for a free port
+	Container portSpec // number or "start-end"
+	HostIP    string   `toml:"host_ip"` // default 127.0.0.1, "0.0.0.0" for every interface
+	Protocol  string   // tcp (default), udp or sctp
+}
+
+// portSpec is a port number, a "start-end" range or, on the host side, "auto".
+type portSpec struct {
+	Start, End int
+	Auto       bool
+}
+
+func (p *portSpec) UnmarshalTOML(value interface{}) error {
+	switch v := value.(type) {
+	case int64:
+		p.Start, p.End = int(v), int(v)
+	case string:
+		if v == "auto" {
+			p.Auto = true
+			return nil
+		}
+		start, end, isRange := strings.Cut(v, "-")
+		var err error
+		if p.Start, err = strconv.Atoi(strings.TrimSpace(start)); err != nil {
+			return fmt.Errorf("invalid port %q", v)
+		}
+		p.End = p.Start
+		if isRange {
+			if p.End, err = strconv.Atoi(strings.TrimSpace(end)); err != nil {
+				return fmt.Errorf("invalid port range %q", v)
+			}
+		}
+	default:
+		return fmt.Errorf("port must be a number, \"start-end\" or \"auto\", got %v", value)
+	}
+	if p.Start < 1 || p.End > 65535 || p.Start > p.End {
+		return fmt.Errorf("invalid port %s", p)
+	}
+	return nil
+}
+
+func (p portSpec) isSet() bool {
+	return p.Auto || p.Start != 0
+}
+
+func (p portSpec) count() int {
+	return p.End - p.Start + 1
+}
+
+func (p portSpec) String() string {
+	switch {
+	case p.Auto:
+		return "auto"
+	case p.Start == p.End:
+		return strconv.Itoa(p.Start)
+	}
+	return fmt.Sprintf("%d-%d", p.Start, p.End)
+}
+
+// declared reports whether the entry says anything; the init template ships
+// an empty [[ports]] table.
+func (m PortMapping) declared() bool {
+	return m.Host.isSet() && m.Container.isSet()
+}
+
+func (m PortMapping) hostIP() string {
+	if m.HostIP == "" {
+		return "127.0.0.1"
+	}
+	return m.HostIP
+}
+
+func (m PortMapping) protocol() string {
+	if m.Protocol == "" {
+		return "tcp"
+	}
+	return strings.ToLower(m.Protocol)
+}
+
+// describe is the entry as shown in the trust prompt.
+func (m PortMapping) describe() string {
+	return fmt.Sprintf("port: %s → container %s/%s", joinHostPort(m.hostIP(), m.Host.String()), m.Container, m.protocol())
+}
+
+func declaredPorts(ports []PortMapping) []PortMapping {
+	var declared []PortMapping
+	for _, port := range ports {
+		if port.declared() {
+			declared = append(declared, port)
+		}
+	}
+	return declared
+}
+
+func checkPorts(ports []PortMapping) error {
+	for _, port := range ports {
+		if !port.declared() {
+			continue
+		}
+		if port.Container.Auto {
+			return fmt.Errorf("Viber00t.toml: container port can't be \"auto\"")
+		}
+		if net.ParseIP(port.hostIP()) == nil {
+			return fmt.Errorf("Viber00t.toml: invalid host_ip %q", port.HostIP)
+		}
+		switch port.protocol() {
+		case "tcp", "udp":
+		case "sctp":
+			if port.Host.Auto {
+				return fmt.Errorf("Viber00t.toml: host = \"auto\" only works for tcp and udp ports")
+			}
+		default:
+			return fmt.Errorf("Viber00t.toml: unknown port protocol %q (tcp, udp or sctp)", port.Protocol)
+		}
+		if !port.Host.Auto && port.Host.count() != port.Container.count() {
+			return fmt.Errorf("Viber00t.toml: port range %s doesn't match container range %s", port.Host, port.Container)
+		}
+	}
+	return nil
+}
+
+// portBinding is a [[ports]] entry with "auto" resolved to a real port.
+type portBinding struct {
+	Name      string
+	HostIP    string
+	Host      portSpec
+	Container portSpec
+	Protocol  string
+	Auto      bool
+}
+
+func (b portBinding) arg() string {
+	return fmt.Sprintf("%s:%s:%s/%s", bracketIP(b.HostIP), b.Host, b.Container, b.Protocol)
+}
+
+func (b portBinding) String() string {
+	return fmt.Sprintf("%s → %s/%s", joinHostPort(b.HostIP, b.Host.String()), b.Container, b.Protocol)
+}
+
+// resolvePorts turns the [[ports]] entries into bindings. "auto" gets a free
+// port for every container port; fixed ports are checked up front so a taken
+// port fails with a hint instead of a podman error.
+func resolvePorts(ports []PortMapping) ([]portBinding, error) {
+	if err := checkPorts(ports); err != nil {
+		return nil, err
+	}
+
+	var bindings []portBinding
+	var held []io.Closer
+	defer func() {
+		for _, l := range held {
+			l.Close()
+		}
+	}()
+	for _, port := range ports {
+		if !port.declared() {
+			continue
+		}
+		ip, protocol := port.hostIP(), port.protocol()
+		if !port.Host.Auto {
+			if !dryRun {
+				for hostPort := port.Host.Start; hostPort <= port.Host.End; hostPort++ {
+					l, err := listenPort(ip, hostPort, protocol)
+					if err != nil {
+						return nil, fmt.Errorf("host port %s is already in use (try host = \"auto\")", joinHostPort(ip, strconv.Itoa(hostPort)))
+					}
+					if l != nil {
+						l.Close()
+					}
+				}
+			}
+			bindings = append(bindings, portBinding{Name: port.Name, HostIP: ip, Host: port.Host, Container: port.Container, Protocol: protocol})
+			continue
+		}
+
+		// Keep every picked port open until all are picked so none repeats
+		for containerPort := port.Container.Start; containerPort <= port.Container.End; containerPort++ {
+			l, err := listenPort(ip, 0, protocol)
+			if err != nil {
+				return nil, fmt.Errorf("no free %s port on %s: %w", protocol, ip, err)
+			}
+			held = append(held, l)
+			hostPort := listenerPort(l)
+			bindings = append(bindings, portBinding{
+				Name:      port.Name,
+				HostIP:    ip,
+				Host:      portSpec{Start: hostPort, End: hostPort},
+				Container: portSpec{Start: containerPort, End: containerPort},
+				Protocol:  protocol,
+				Auto:      true,
+			})
+		}
+	}
+	return bindings, nil
+}
+
+// listenPort binds ip:port, returning nil for protocols Go can't listen on.
+func listenPort(ip string, port int, protocol string) (io.Closer, error) {
+	address := joinHostPort(ip, strconv.Itoa(port))
+	switch protocol {
+	case "tcp":
+		return net.Listen("tcp", address)
+	case "udp":
+		return net.ListenPacket("udp", address)
+	}
+	return nil, nil
+}
+
+func listenerPort(l io.Closer) int {
+	switch l := l.(type) {
+	case net.Listener:
+		return l.Addr().(*net.TCPAddr).Port
+	case net.PacketConn:
+		return l.LocalAddr().(*net.UDPAddr).Port
+	}
+	return 0
+}
+
+func joinHostPort(ip, port string) string {
+	return bracketIP(ip) + ":" + port
+}
+
+func bracketIP(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "[" + ip + "]"
+	}
+	return ip
+}
+
+// showPorts prints the live port mappings of this project's agent and shell containers.
+func showPorts() {
+	cwd, _ := os.Getwd()
+	found := false
+	for _, name := range []string{"viber00t-" + filepath.Base(cwd), "viber00t-shell-" + filepath.Base(cwd)} {
+		output, err := exec.Command("podman", "port", name).Output()
+		if err != nil {
+			continue // not running
+		}
+		found = true
+		fmt.Printf("\033[36m%s\033[0m\n", name)
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		if lines[0] == "" {
+			fmt.Println("  \033[90mno published ports\033[0m")
+			continue
+		}
+		for _, line := range lines {
+			// "3000/tcp -> 127.0.0.1:49153"
+			containerPort, hostAddress, _ := strings.Cut(line, " -> ")
+			fmt.Printf("  %s → %s\n", hostAddress, containerPort)
+		}
+	}
+	if !found {
+		fmt.Println("\033[90mNo running containers for this project\033[0m")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestPortSpecUnmarshal(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    portSpec
+		wantErr bool
+	}{
+		{`8080`, portSpec{Start: 8080, End: 8080}, false},
+		{`"8080"`, portSpec{Start: 8080, End: 8080}, false},
+		{`"8000-8010"`, portSpec{Start: 8000, End: 8010}, false},
+		{`"8000 - 8010"`, portSpec{Start: 8000, End: 8010}, false},
+		{`"auto"`, portSpec{Auto: true}, false},
+		{`0`, portSpec{}, true},
+		{`65536`, portSpec{}, true},
+		{`"8010-8000"`, portSpec{}, true},
+		{`"80-"`, portSpec{}, true},
+		{`"http"`, portSpec{}, true},
+		{`8080.5`, portSpec{}, true},
+	}
+	for _, tt := range tests {
+		var decoded struct{ Port portSpec }
+		_, err := toml.Decode("port = "+tt.value, &decoded)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("port = %s: err = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && decoded.Port != tt.want {
+			t.Errorf("port = %s: got %+v, want %+v", tt.value, decoded.Port, tt.want)
+		}
+	}
+}
+
+func TestCheckPorts(t *testing.T) {
+	port := func(host, container portSpec) PortMapping {
+		return PortMapping{Host: host, Container: container}
+	}
+	single := func(n int) portSpec { return portSpec{Start: n, End: n} }
+	auto := portSpec{Auto: true}
+
+	tests := []struct {
+		name    string
+		port    PortMapping
+		wantErr string
+	}{
+		{"single", port(single(8080), single(80)), ""},
+		{"range", port(portSpec{Start: 8000, End: 8002}, portSpec{Start: 3000, End: 3002}), ""},
+		{"auto host", port(auto, portSpec{Start: 3000, End: 3002}), ""},
+		{"empty template entry", PortMapping{}, ""},
+		{"auto container", port(single(8080), auto), "container port"},
+		{"range mismatch", port(portSpec{Start: 8000, End: 8002}, single(80)), "doesn't match"},
+		{"bad host_ip", PortMapping{Host: single(80), Container: single(80), HostIP: "localhost"}, "host_ip"},
+		{"ipv6 host_ip", PortMapping{Host: single(80), Container: single(80), HostIP: "::1"}, ""},
+		{"udp", PortMapping{Host: single(53), Container: single(53), Protocol: "UDP"}, ""},
+		{"sctp auto", PortMapping{Host: auto, Container: single(80), Protocol: "sctp"}, "only works for tcp and udp"},
+		{"unknown protocol", PortMapping{Host: single(80), Container: single(80), Protocol: "quic"}, "unknown port protocol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPorts([]PortMapping{tt.port})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolvePorts(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on loopback:", err)
+	}
+	defer taken.Close()
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+
+	t.Run("auto picks distinct ports", func(t *testing.T) {
+		bindings, err := resolvePorts([]PortMapping{
+			{Host: portSpec{Auto: true}, Container: portSpec{Start: 3000, End: 3002}},
+			{Host: portSpec{Auto: true}, Container: portSpec{Start: 4000, End: 4000}, Protocol: "udp"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bindings) != 4 {
+			t.Fatalf("got %d bindings, want 4", len(bindings))
+		}
+		seen := map[int]bool{}
+		for i, b := range bindings[:3] {
+			if !b.Auto || b.Container.Start != 3000+i || b.Host.Start == 0 || seen[b.Host.Start] {
+				t.Errorf("binding %d: %+v", i, b)
+			}
+			seen[b.Host.Start] = true
+		}
+		if bindings[3].Protocol != "udp" {
+			t.Errorf("udp binding: %+v", bindings[3])
+		}
+	})
+
+	t.Run("taken port", func(t *testing.T) {
+		_, err := resolvePorts([]PortMapping{{Host: portSpec{Start: takenPort, End: takenPort}, Container: portSpec{Start: 80, End: 80}}})
+		if err == nil || !strings.Contains(err.Error(), "already in use") {
+			t.Errorf("err = %v, want already in use", err)
+		}
+	})
+
+	t.Run("taken port in dry run", func(t *testing.T) {
+		dryRun = true
+		defer func() { dryRun = false }()
+		bindings, err := resolvePorts([]PortMapping{{Host: portSpec{Start: takenPort, End: takenPort}, Container: portSpec{Start: 80, End: 80}}})
+		if err != nil || len(bindings) != 1 {
+			t.Errorf("got %v, %v, want one binding", bindings, err)
+		}
+	})
+}
+
+func TestPortBindingArg(t *testing.T) {
+	tests := []struct {
+		binding portBinding
+		want    string
+	}{
+		{portBinding{HostIP: "127.0.0.1", Host: portSpec{Start: 8080, End: 8080}, Container: portSpec{Start: 80, End: 80}, Protocol: "tcp"}, "127.0.0.1:8080:80/tcp"},
+		{portBinding{HostIP: "::1", Host: portSpec{Start: 8000, End: 8001}, Container: portSpec{Start: 3000, End: 3001}, Protocol: "udp"}, "[::1]:8000-8001:3000-3001/udp"},
+	}
+	for _, tt := range tests {
+		if got := tt.binding.arg(); got != tt.want {
+			t.Errorf("arg() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyConfig is the global [proxy] section. When enabled, a shared reverse
+// proxy routes http://<service>.<project>.localhost:<port> to the sessions'
+// ports.
+type ProxyConfig struct {
+	Enabled bool
+	Port    int // default 7080
+}
+
+const (
+	defaultProxyPort = 7080
+	proxyIdleExit    = 10 * time.Minute
+	proxyPingPath    = "/.viber00t-proxy"
+)
+
+// RouteEntry is one session's routes, registered while the session runs.
+type RouteEntry struct {
+	Project string            `json:"project"`
+	Session string            `json:"session"`
+	PID     int               `json:"pid"`
+	Routes  map[string]string `json:"routes"`            // service → host address
+	Default string            `json:"default,omitempty"` // service behind <project>.localhost
+}
+
+func getRoutesDir() string {
+	return filepath.Join(getXDGStateHome(), "viber00t", "routes")
+}
+
+func (c ProxyConfig) port() int {
+	if c.Port == 0 {
+		return defaultProxyPort
+	}
+	return c.Port
+}
+
+var hostLabelInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// hostLabel turns a project or service name into a DNS label.
+func hostLabel(name string) string {
+	return strings.Trim(hostLabelInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func proxyURL(project, service string, port int) string {
+	host := hostLabel(project) + ".localhost"
+	if service != "" {
+		host = hostLabel(service) + "." + host
+	}
+	return fmt.Sprintf("http://%s:%d", host, port)
+}
+
+// sessionRoutes is the registry file of a running session. A nil
+// *sessionRoutes means the proxy is off; its methods do nothing then.
+type sessionRoutes struct {
+	mu    sync.Mutex
+	path  string
+	port  int
+	entry RouteEntry
+}
+
+// registerRoutes publishes the session's [[ports]] and service ports to the
+// proxy, starting the proxy if it isn't running yet.
+func registerRoutes(config *Config, globalConfig *GlobalConfig, session *Session, published []portBinding) *sessionRoutes {
+	if !globalConfig.Proxy.Enabled || dryRun {
+		return nil
+	}
+	if err := ensureProxy(globalConfig.Proxy.port()); err != nil {
+		fmt.Printf("\033[33m⚠\033[0m  Proxy not started: %v\n", err)
+		return nil
+	}
+
+	r := &sessionRoutes{
+		path: filepath.Join(getRoutesDir(), session.ID+".json"),
+		port: globalConfig.Proxy.port(),
+		entry: RouteEntry{
+			Project: config.Project.Name,
+			Session: session.ID,
+			PID:     os.Getpid(),
+			Routes:  make(map[string]string),
+		},
+	}
+	for _, binding := range published {
+		if binding.Protocol != "tcp" {
+			continue
+		}
+		for i := 0; i < binding.Container.count(); i++ {
+			containerPort := binding.Container.Start + i
+			address := joinHostPort(loopbackFor(binding.HostIP), strconv.Itoa(binding.Host.Start+i))
+			service := strconv.Itoa(containerPort)
+			if binding.Name != "" && binding.Container.count() == 1 {
+				service = binding.Name
+			}
+			if _, taken := r.entry.Routes[hostLabel(service)]; taken {
+				continue
+			}
+			r.entry.Routes[hostLabel(service)] = address
+			fmt.Printf("\033[35m◉\033[0m %s → :%d\n", proxyURL(config.Project.Name, service, r.port), containerPort)
+			if r.entry.Default == "" || service == "web" {
+				r.entry.Default = hostLabel(service)
+			}
+		}
+	}
+	if err := r.save(); err != nil {
+		fmt.Printf("\033[33m⚠\033[0m  Failed to register proxy routes: %v\n", err)
+		return nil
+	}
+	session.onFinish(func() { os.Remove(r.path) })
+	return r
+}
+
+// loopbackFor is where the proxy reaches a port bound to ip.
+func loopbackFor(ip string) string {
+	switch ip {
+	case "0.0.0.0":
+		return "127.0.0.1"
+	case "::":
+		return "::1"
+	}
+	return ip
+}
+
+func (r *sessionRoutes) save() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r.entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, data, 0600)
+}
+
+// add routes service to address unless the name is taken, returning its URL.
+func (r *sessionRoutes) add(service, address string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	label := hostLabel(service)
+	if _, taken := r.entry.Routes[label]; taken || label == "" {
+		return ""
+	}
+	r.entry.Routes[label] = address
+	if r.entry.Default == "" {
+		r.entry.Default = label
+	}
+	r.save()
+	return proxyURL(r.entry.Project, service, r.port)
+}
+
+func (r *sessionRoutes) remove(address string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for label, target := range r.entry.Routes {
+		if target == address {
+			delete(r.entry.Routes, label)
+			if r.entry.Default == label {
+				r.entry.Default = ""
+			}
+		}
+	}
+	r.save()
+}
+
+// loadRoutes reads the registry, dropping entries of sessions that are gone.
+func loadRoutes() []RouteEntry {
+	files, _ := ioutil.ReadDir(getRoutesDir())
+	var entries []RouteEntry
+	for _, file := range files {
+		path := filepath.Join(getRoutesDir(), file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry RouteEntry
+		if json.Unmarshal(data, &entry) != nil || !processAlive(entry.PID) {
+			os.Remove(path)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	// Newest session wins when a project runs twice
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Session > entries[j].Session })
+	return entries
+}
+
+// lookupRoute finds the address behind <service>.<project>.localhost.
+func lookupRoute(entries []RouteEntry, project, service string) (string, bool) {
+	for _, entry := range entries {
+		if hostLabel(entry.Project) != project {
+			continue
+		}
+		if service == "" {
+			service = entry.Default
+		}
+		if address, ok := entry.Routes[service]; ok {
+			return address, true
+		}
+	}
+	return "", false
+}
+
+// ensureProxy starts the shared proxy in the background unless it already runs.
+func ensureProxy(port int) error {
+	switch pingProxy(port) {
+	case nil:
+		return nil
+	case errProxyForeign:
+		return fmt.Errorf("port %d is in use by something else, set [proxy] port in the global config", port)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(getXDGStateHome(), "viber00t", "proxy.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "_proxy", strconv.Itoa(port))
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	cmd.Process.Release()
+
+	for i := 0; i < 20; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if pingProxy(port) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("proxy didn't come up, see %s", logFile.Name())
+}
+
+var errProxyForeign = errors.New("not a viber00t proxy")
+
+func pingProxy(port int) error {
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d%s", port, proxyPingPath))
+	if err != nil {
+		if _, dialErr := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 200*time.Millisecond); dialErr == nil {
+			return errProxyForeign
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Viber00t-Proxy") == "" {
+		return errProxyForeign
+	}
+	return nil
+}
+
+// runProxy is the shared proxy process, started on demand as "viber00t _proxy <port>".
+// It exits once no session has had routes for a while.
+func runProxy(args []string) {
+	port := defaultProxyPort
+	if len(args) > 0 {
+		port, _ = strconv.Atoi(args[0])
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		log.Fatal("viber00t proxy: ", err)
+	}
+	pidFile := filepath.Join(getXDGStateHome(), "viber00t", "proxy.pid")
+	ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0600)
+	log.Printf("viber00t proxy listening on 127.0.0.1:%d", port)
+
+	go func() {
+		idleSince := time.Now()
+		for range time.Tick(time.Minute) {
+			if len(loadRoutes()) > 0 {
+				idleSince = time.Now()
+			} else if time.Since(idleSince) > proxyIdleExit {
+				log.Printf("no sessions for %s, exiting", proxyIdleExit)
+				os.Remove(pidFile)
+				os.Exit(0)
+			}
+		}
+	}()
+	log.Fatal(http.Serve(listener, proxyHandler(port)))
+}
+
+func proxyHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Viber00t-Proxy", "1")
+		host := strings.ToLower(req.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if req.URL.Path == proxyPingPath && (host == "localhost" || host == "127.0.0.1") {
+			fmt.Fprintln(w, "viber00t proxy")
+			return
+		}
+
+		entries := loadRoutes()
+		if !strings.HasSuffix(host, ".localhost") {
+			writeRouteIndex(w, entries, port, http.StatusOK)
+			return
+		}
+		labels := strings.Split(strings.TrimSuffix(host, ".localhost"), ".")
+		project, service := labels[len(labels)-1], strings.Join(labels[:len(labels)-1], ".")
+		address, ok := lookupRoute(entries, project, service)
+		if !ok {
+			writeRouteIndex(w, entries, port, http.StatusNotFound)
+			return
+		}
+
+		// Host stays as requested so dev servers see the name they're served under;
+		// httputil handles websocket upgrades.
+		proxy := &httputil.ReverseProxy{
+			Director: func(out *http.Request) {
+				out.URL.Scheme = "http"
+				out.URL.Host = address
+				out.Header.Set("X-Forwarded-Host", req.Host)
+				out.Header.Set("X-Forwarded-Proto", "http")
+			},
+			ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+				w.WriteHeader(http.StatusBadGateway)
+				fmt.Fprintf(w, "viber00t: %s isn't answering (%v)\n", host, err)
+			},
+		}
+		proxy.ServeHTTP(w, req)
+	})
+}
+
+func writeRouteIndex(w http.ResponseWriter, entries []RouteEntry, port, status int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if status == http.StatusNotFound {
+		fmt.Fprintln(w, "viber00t: no such service")
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No running sessions have routes")
+		return
+	}
+	for _, line := range routeLines(entries, port) {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func routeLines(entries []RouteEntry, port int) []string {
+	var lines []string
+	for _, entry := range entries {
+		services := make([]string, 0, len(entry.Routes))
+		for service := range entry.Routes {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		for _, service := range services {
+			lines = append(lines, fmt.Sprintf("%-44s → %s", proxyURL(entry.Project, service, port), entry.Routes[service]))
+		}
+		if entry.Default != "" {
+			lines = append(lines, fmt.Sprintf("%-44s → %s", proxyURL(entry.Project, "", port), entry.Routes[entry.Default]))
+		}
+	}
+	return lines
+}
+
+// showProxy prints the proxy's routes, or stops it with "viber00t proxy stop".
+func showProxy(args []string) {
+	globalConfig, _ := loadGlobalConfig()
+	port := globalConfig.Proxy.port()
+	pidFile := filepath.Join(getXDGStateHome(), "viber00t", "proxy.pid")
+
+	if len(args) > 0 && args[0] == "stop" {
+		data, err := ioutil.ReadFile(pidFile)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || !processAlive(pid) {
+			fmt.Println("\033[90mProxy isn't running\033[0m")
+			return
+		}
+		if err := stopProcess(pid); err != nil {
+			log.Fatal("\033[31m✗\033[0m ", err)
+		}
+		os.Remove(pidFile)
+		fmt.Println("\033[32m✓\033[0m Proxy stopped")
+		return
+	}
+
+	if !globalConfig.Proxy.Enabled {
+		fmt.Println("\033[90mProxy is off. Set [proxy] enabled = true in the global config.\033[0m")
+		return
+	}
+	if pingProxy(port) == nil {
+		fmt.Printf("\033[35m◉\033[0m Proxy on 127.0.0.1:%d\n", port)
+	} else {
+		fmt.Printf("\033[90mProxy not running, it starts with the next session (port %d)\033[0m\n", port)
+	}
+	lines := routeLines(loadRoutes(), port)
+	if len(lines) == 0 {
+		fmt.Println("\033[90mNo running sessions have routes\033[0m")
+	}
+	for _, line := range lines {
+		fmt.Println("  " + line)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupRoute(t *testing.T) {
+	entries := []RouteEntry{
+		{Project: "My App", Routes: map[string]string{"web": "127.0.0.1:3000", "api": "127.0.0.1:8000"}, Default: "web"},
+		{Project: "other", Routes: map[string]string{"web": "127.0.0.1:4000"}},
+	}
+	tests := []struct {
+		project, service string
+		want             string
+	}{
+		{"my-app", "api", "127.0.0.1:8000"},
+		{"my-app", "", "127.0.0.1:3000"},
+		{"other", "web", "127.0.0.1:4000"},
+		// No default service set
+		{"other", "", ""},
+		{"my-app", "db", ""},
+		{"nope", "web", ""},
+	}
+	for _, tt := range tests {
+		got, ok := lookupRoute(entries, tt.project, tt.service)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("lookupRoute(%q, %q) = %q, %v, want %q", tt.project, tt.service, got, ok, tt.want)
+		}
+	}
+}
+
+// writeRoutes registers a session's routes the way a running session does.
+func writeRoutes(t *testing.T, entry RouteEntry) string {
+	t.Helper()
+	path := filepath.Join(getRoutesDir(), entry.Session+".json")
+	data, _ := json.Marshal(entry)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// backend answers with its name and the Host it was asked for.
+func backend(t *testing.T, name string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", name, req.Host, req.Header.Get("X-Forwarded-Host"))
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func TestProxyHandler(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	writeRoutes(t, RouteEntry{
+		Project: "demo",
+		Session: "demo-1",
+		PID:     os.Getpid(),
+		Routes:  map[string]string{"web": backend(t, "web"), "api": backend(t, "api")},
+		Default: "web",
+	})
+	stale := writeRoutes(t, RouteEntry{Project: "gone", Session: "gone-1", PID: 0, Routes: map[string]string{"web": "127.0.0.1:1"}})
+
+	proxy := httptest.NewServer(proxyHandler(7080))
+	defer proxy.Close()
+
+	tests := []struct {
+		host   string
+		status int
+		body   string
+	}{
+		{"api.demo.localhost:7080", http.StatusOK, "api api.demo.localhost:7080 api.demo.localhost:7080"},
+		{"demo.localhost:7080", http.StatusOK, "web demo.localhost:7080 demo.localhost:7080"},
+		{"WEB.Demo.localhost", http.StatusOK, "web WEB.Demo.localhost"},
+		{"db.demo.localhost", http.StatusNotFound, "no such service"},
+		{"web.gone.localhost", http.StatusNotFound, "no such service"},
+		// Anything else gets the route index
+		{"example.com", http.StatusOK, "http://api.demo.localhost:7080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", proxy.URL+"/", nil)
+			req.Host = tt.host
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || !strings.Contains(string(body), tt.body) {
+				t.Errorf("got %d %q, want %d with %q", resp.StatusCode, body, tt.status, tt.body)
+			}
+			if resp.Header.Get("X-Viber00t-Proxy") == "" {
+				t.Errorf("response not marked as coming from the proxy")
+			}
+		})
+	}
+
+	// Routes of sessions that are gone are dropped
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale route file kept: %v", err)
+	}
+}
+
+func TestPingProxy(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	port := func(addr net.Addr) int { return addr.(*net.TCPAddr).Port }
+
+	ours := httptest.NewServer(proxyHandler(0))
+	defer ours.Close()
+	if err := pingProxy(port(ours.Listener.Addr())); err != nil {
+		t.Errorf("own proxy: %v", err)
+	}
+
+	foreign := httptest.NewServer(http.NotFoundHandler())
+	defer foreign.Close()
+	if err := pingProxy(port(foreign.Listener.Addr())); err != errProxyForeign {
+		t.Errorf("foreign web server: %v, want errProxyForeign", err)
+	}
+
+	// Something that isn't HTTP at all
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := raw.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	if err := pingProxy(port(raw.Addr())); err != errProxyForeign {
+		t.Errorf("non-HTTP listener: %v, want errProxyForeign", err)
+	}
+
+	// Nothing listening
+	free := port(raw.Addr())
+	raw.Close()
+	if err := pingProxy(free); err == nil || err == errProxyForeign {
+		t.Errorf("free port: %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errNoPTY = errors.New("pseudo terminals are only supported on linux")
+
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, errNoPTY
+}
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func getWinsize(f *os.File) (rows, cols int, err error) {
+	return 0, 0, errNoPTY
+}
+
+func setWinsize(f *os.File, rows, cols int) error {
+	return errNoPTY
+}
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errNoPTY
+}
+
+func ptyAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func notifyResize(ch chan os.Signal) {}
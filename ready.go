@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ReadyCheck is a readiness gate: [[ready]] for the project, ready = [...] on
+// a service. Every check runs inside the project container before the agent or
+// shell gets the terminal; tcp and http on a service default to its host name.
+type ReadyCheck struct {
+	TCP      string // "host:port", or just the port
+	HTTP     string // URL or path, ready on a 2xx/3xx answer
+	Command  string // ready when it exits 0
+	File     string // ready once it exists
+	Timeout  string // default 60s
+	Interval string // default 1s
+	Retries  int    // give up after this many failed attempts, default until the timeout
+}
+
+// readyProbe is a resolved check, handed to the container init as JSON.
+type readyProbe struct {
+	Kind     string        `json:"kind"`
+	Target   string        `json:"target"`
+	Timeout  time.Duration `json:"timeout"`
+	Interval time.Duration `json:"interval"`
+	Retries  int           `json:"retries,omitempty"`
+}
+
+const (
+	defaultReadyTimeout  = 60 * time.Second
+	defaultReadyInterval = time.Second
+	containerReadyEnv    = "VIBER00T_READY"
+)
+
+func (c ReadyCheck) declared() bool {
+	return c.TCP != "" || c.HTTP != "" || c.Command != "" || c.File != ""
+}
+
+// probe validates the check and resolves it; host is where a service's tcp
+// and http checks point by default.
+func (c ReadyCheck) probe(host string) (readyProbe, error) {
+	var p readyProbe
+	set := 0
+	for kind, target := range map[string]string{"tcp": c.TCP, "http": c.HTTP, "command": c.Command, "file": c.File} {
+		if target != "" {
+			p.Kind, p.Target = kind, target
+			set++
+		}
+	}
+	if set != 1 {
+		return p, fmt.Errorf("a ready check needs exactly one of tcp, http, command or file")
+	}
+
+	switch p.Kind {
+	case "tcp":
+		if !strings.Contains(p.Target, ":") {
+			p.Target = host + ":" + p.Target
+		}
+		if _, _, err := net.SplitHostPort(p.Target); err != nil {
+			return p, fmt.Errorf("invalid tcp check %q", c.TCP)
+		}
+	case "http":
+		if strings.HasPrefix(p.Target, "/") {
+			p.Target = "http://" + host + p.Target
+		} else if !strings.Contains(p.Target, "://") {
+			p.Target = "http://" + p.Target
+		}
+	}
+
+	var err error
+	if p.Timeout, err = parseLimit("timeout", c.Timeout); err != nil {
+		return p, err
+	}
+	if p.Interval, err = parseLimit("interval", c.Interval); err != nil {
+		return p, err
+	}
+	if p.Timeout == 0 {
+		p.Timeout = defaultReadyTimeout
+	}
+	if p.Interval == 0 {
+		p.Interval = defaultReadyInterval
+	}
+	p.Retries = c.Retries
+	return p, nil
+}
+
+func (p readyProbe) String() string {
+	return fmt.Sprintf("%s %s", p.Kind, p.Target)
+}
+
+// resolveReadyChecks collects the project's and its services' checks.
+func resolveReadyChecks(config *Config) ([]readyProbe, error) {
+	var probes []readyProbe
+	for _, check := range config.Ready {
+		if !check.declared() {
+			continue
+		}
+		probe, err := check.probe("localhost")
+		if err != nil {
+			return nil, fmt.Errorf("Viber00t.toml: [[ready]]: %w", err)
+		}
+		probes = append(probes, probe)
+	}
+	for _, service := range declaredServices(config) {
+		for _, check := range service.Ready {
+			probe, err := check.probe(service.Name)
+			if err != nil {
+				return nil, fmt.Errorf("Viber00t.toml: service %s: %w", service.Name, err)
+			}
+			probes = append(probes, probe)
+		}
+	}
+	return probes, nil
+}
+
+// readyArgs passes the checks to the container init.
+func readyArgs(probes []readyProbe) []string {
+	if len(probes) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(probes)
+	return []string{"-e", containerReadyEnv + "=" + string(data)}
+}
+
+// attempt runs the check once.
+func (p readyProbe) attempt() error {
+	switch p.Kind {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", p.Target, 2*time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http":
+		// Straight to the service, never through the egress proxy
+		client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{Proxy: nil}}
+		resp, err := client.Get(p.Target)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		return nil
+	case "command":
+		output, err := exec.Command("sh", "-c", p.Target).CombinedOutput()
+		if err != nil {
+			if out := strings.TrimSpace(string(output)); out != "" {
+				return fmt.Errorf("%v: %s", err, lastLine(out))
+			}
+		}
+		return err
+	case "file":
+		_, err := os.Stat(p.Target)
+		return err
+	}
+	return fmt.Errorf("unknown check %s", p.Kind)
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// wait retries the check until it passes, the timeout runs out or the
+// retries are used up, returning the last error.
+func (p readyProbe) wait() (time.Duration, int, error) {
+	start := time.Now()
+	for attempts := 1; ; attempts++ {
+		err := p.attempt()
+		if err == nil {
+			return time.Since(start), attempts, nil
+		}
+		if (p.Retries > 0 && attempts >= p.Retries) || time.Since(start)+p.Interval > p.Timeout {
+			return time.Since(start), attempts, err
+		}
+		time.Sleep(p.Interval)
+	}
+}
+
+// waitReady runs inside the container (viber00t _init --ready) and checks
+// everything in parallel, printing each result as it comes in.
+func waitReady(encoded string) bool {
+	var probes []readyProbe
+	if err := json.Unmarshal([]byte(encoded), &probes); err != nil {
+		fmt.Fprintf(os.Stderr, "viber00t: invalid ready checks: %v\n", err)
+		return false
+	}
+
+	type result struct {
+		probe    readyProbe
+		elapsed  time.Duration
+		attempts int
+		err      error
+	}
+	results := make(chan result)
+	for _, probe := range probes {
+		go func(probe readyProbe) {
+			elapsed, attempts, err := probe.wait()
+			results <- result{probe, elapsed, attempts, err}
+		}(probe)
+	}
+
+	fmt.Printf("\033[35m◉\033[0m Waiting for %d ready check(s)\n", len(probes))
+	var failed []result
+	for range probes {
+		r := <-results
+		if r.err != nil {
+			fmt.Printf("  \033[31m✗\033[0m %s\n", r.probe)
+			failed = append(failed, r)
+			continue
+		}
+		fmt.Printf("  \033[32m✓\033[0m %s \033[90m%.1fs\033[0m\n", r.probe, r.elapsed.Seconds())
+	}
+	if len(failed) == 0 {
+		return true
+	}
+
+	fmt.Printf("\033[31m✗\033[0m Environment not ready:\n")
+	for _, r := range failed {
+		fmt.Printf("  %s: %v \033[90m(%d attempts in %.0fs)\033[0m\n", r.probe, r.err, r.attempts, r.elapsed.Seconds())
+	}
+	fmt.Println("\033[90mCheck the services with 'viber00t services', or adjust [[ready]] in Viber00t.toml\033[0m")
+	return false
+}
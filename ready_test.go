@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyCheckProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   ReadyCheck
+		want    readyProbe
+		wantErr string
+	}{
+		{"bare port", ReadyCheck{TCP: "5432"}, readyProbe{Kind: "tcp", Target: "db:5432"}, ""},
+		{"tcp address", ReadyCheck{TCP: "cache:6379"}, readyProbe{Kind: "tcp", Target: "cache:6379"}, ""},
+		{"http path", ReadyCheck{HTTP: "/health"}, readyProbe{Kind: "http", Target: "http://db/health"}, ""},
+		{"http without scheme", ReadyCheck{HTTP: "api:8080/up"}, readyProbe{Kind: "http", Target: "http://api:8080/up"}, ""},
+		{"https URL", ReadyCheck{HTTP: "https://example.com/"}, readyProbe{Kind: "http", Target: "https://example.com/"}, ""},
+		{"command", ReadyCheck{Command: "pg_isready"}, readyProbe{Kind: "command", Target: "pg_isready"}, ""},
+		{
+			"timing",
+			ReadyCheck{File: "/tmp/up", Timeout: "2m", Interval: "5s", Retries: 3},
+			readyProbe{Kind: "file", Target: "/tmp/up", Timeout: 2 * time.Minute, Interval: 5 * time.Second, Retries: 3},
+			"",
+		},
+		{"nothing", ReadyCheck{Timeout: "5s"}, readyProbe{}, "exactly one"},
+		{"two kinds", ReadyCheck{TCP: "80", File: "/tmp/up"}, readyProbe{}, "exactly one"},
+		{"bad timeout", ReadyCheck{TCP: "80", Timeout: "soon"}, readyProbe{}, "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.check.probe("db")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want.Timeout == 0 {
+				tt.want.Timeout, tt.want.Interval = defaultReadyTimeout, defaultReadyInterval
+			}
+			if got != tt.want {
+				t.Errorf("probe %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveReadyChecks(t *testing.T) {
+	config := decodeConfig(t, `
+[[ready]]
+tcp = "3000"
+
+[[ready]]
+
+[[services]]
+name = "db"
+image = "postgres:16"
+ready = [{ tcp = "5432" }, { command = "pg_isready" }]
+`)
+	probes, err := resolveReadyChecks(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, probe := range probes {
+		got = append(got, probe.String())
+	}
+	// Project checks run against the container itself, service checks against the service
+	want := []string{"tcp localhost:3000", "tcp db:5432", "command pg_isready"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("probes %q, want %q", got, want)
+	}
+
+	args := readyArgs(probes)
+	if len(args) != 2 || args[0] != "-e" || !strings.HasPrefix(args[1], containerReadyEnv+"=") {
+		t.Fatalf("args %q", args)
+	}
+	var decoded []readyProbe
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(args[1], containerReadyEnv+"=")), &decoded); err != nil || !reflect.DeepEqual(decoded, probes) {
+		t.Errorf("probes don't survive the trip to the container: %v", err)
+	}
+	if readyArgs(nil) != nil {
+		t.Errorf("args without checks")
+	}
+
+	bad := decodeConfig(t, "[[services]]\nname = \"db\"\nimage = \"postgres\"\nready = [{ tcp = \"5432\", file = \"/x\" }]\n")
+	if _, err := resolveReadyChecks(bad); err == nil || !strings.Contains(err.Error(), "service db") {
+		t.Errorf("err = %v, want it to name the service", err)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	present := filepath.Join(t.TempDir(), "present")
+	writeTree(t, filepath.Dir(present), map[string]string{"present": ""})
+	quick := func(kind, target string, retries int) readyProbe {
+		return readyProbe{Kind: kind, Target: target, Timeout: time.Second, Interval: 10 * time.Millisecond, Retries: retries}
+	}
+	encode := func(probes ...readyProbe) string {
+		data, _ := json.Marshal(probes)
+		return string(data)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		want    bool
+	}{
+		{"all pass", encode(quick("file", present, 0), quick("command", "true", 0)), true},
+		{"one fails", encode(quick("file", present, 0), quick("command", "false", 3)), false},
+		{"none", encode(), true},
+		{"garbage", "not json", false},
+	}
+	for _, tt := range tests {
+		if got := waitReady(tt.encoded); got != tt.want {
+			t.Errorf("%s: ready = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadyProbeWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "up")
+	probe := readyProbe{Kind: "file", Target: path, Timeout: time.Minute, Interval: 10 * time.Millisecond, Retries: 4}
+	_, attempts, err := probe.wait()
+	if err == nil || attempts != 4 {
+		t.Errorf("missing file: %d attempts, err %v, want 4 attempts and an error", attempts, err)
+	}
+
+	probe.Retries = 0
+	probe.Timeout = 50 * time.Millisecond
+	elapsed, _, err := probe.wait()
+	if err == nil || elapsed > time.Second {
+		t.Errorf("timeout: gave up after %s with %v", elapsed, err)
+	}
+
+	writeTree(t, filepath.Dir(path), map[string]string{"up": ""})
+	if _, attempts, err := probe.wait(); err != nil || attempts != 1 {
+		t.Errorf("present file: %d attempts, err %v", attempts, err)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castRecorder writes terminal output as an asciicast v2 file.
+type castRecorder struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	f       *os.File
+	start   time.Time
+	pending []byte
+}
+
+func newCastRecorder(path string, rows, cols int, title string) (*castRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &castRecorder{w: bufio.NewWriter(f), f: f, start: time.Now()}
+	header, _ := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     cols,
+		"height":    rows,
+		"timestamp": r.start.Unix(),
+		"title":     title,
+		"env": map[string]string{
+			"TERM":  os.Getenv("TERM"),
+			"SHELL": os.Getenv("SHELL"),
+		},
+	})
+	r.w.Write(header)
+	r.w.WriteString("\n")
+	return r, nil
+}
+
+func (r *castRecorder) event(kind, data string) {
+	line, _ := json.Marshal([]interface{}{
+		float64(time.Since(r.start).Microseconds()) / 1e6, kind, data,
+	})
+	r.w.Write(line)
+	r.w.WriteString("\n")
+}
+
+// castLineLimit bounds how much output without a newline is held back.
+const castLineLimit = 4096
+
+// Write records output with secrets redacted. Output is flushed up to the last
+// line break so a secret split across writes is still caught; a trailing
+// partial line is held back until the rest of it arrives.
+func (r *castRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.pending, p...)
+	cut := bytes.LastIndexByte(data, '\n') + 1
+	if cut == 0 && len(data) > castLineLimit {
+		// A long line, keep enough back to still catch a secret at its end
+		cut = len(data) - longestSecret()
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			// Nor cut into a trailing partial UTF-8 sequence
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) && i < cut {
+					cut = i
+				}
+				break
+			}
+		}
+		for cut > 0 && cut < len(data) && !utf8.RuneStart(data[cut]) {
+			cut--
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.event("o", redact(string(data[:cut])))
+	}
+	return len(p), nil
+}
+
+func (r *castRecorder) resize(rows, cols int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (r *castRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		r.event("o", redact(string(r.pending)))
+	}
+	r.w.Flush()
+	return r.f.Close()
+}
+
+func recordingEnabled(config *Config, globalConfig *GlobalConfig) bool {
+	if config.Recording.Enabled != nil {
+		return *config.Recording.Enabled
+	}
+	return globalConfig.Recording.Enabled
+}
+
+// runProxied runs cmd attached to the terminal through a pty proxy, records
+// everything it prints to castPath unless that is empty, and reports terminal
+// activity to touch.
+func runProxied(cmd *exec.Cmd, castPath, title string, touch func()) error {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		// Nothing to proxy, just tee the output
+		stdout, stderr := io.Writer(os.Stdout), io.Writer(os.Stderr)
+		if castPath != "" {
+			rec, err := newCastRecorder(castPath, 24, 80, title)
+			if err != nil {
+				return err
+			}
+			defer rec.Close()
+			stdout, stderr = io.MultiWriter(os.Stdout, rec), io.MultiWriter(os.Stderr, rec)
+		}
+		cmd.Stdin = activityReader{os.Stdin, touch}
+		cmd.Stdout = activityWriter{stdout, touch}
+		cmd.Stderr = activityWriter{stderr, touch}
+		return cmd.Run()
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		return fmt.Errorf("failed to allocate pty: %w", err)
+	}
+	defer master.Close()
+
+	rows, cols, err := getWinsize(os.Stdin)
+	if err != nil || rows == 0 || cols == 0 {
+		rows, cols = 24, 80
+	}
+	setWinsize(slave, rows, cols)
+
+	var rec *castRecorder
+	output := io.Writer(os.Stdout)
+	if castPath != "" {
+		if rec, err = newCastRecorder(castPath, rows, cols, title); err != nil {
+			slave.Close()
+			return err
+		}
+		defer rec.Close()
+		output = io.MultiWriter(os.Stdout, rec)
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = ptyAttr()
+	if err := cmd.Start(); err != nil {
+		slave.Close()
+		return err
+	}
+	slave.Close()
+
+	restore, err := makeRaw(os.Stdin)
+	if err == nil {
+		defer restore()
+	}
+
+	// Forward terminal resizes to the pty and the recording
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	defer signal.Stop(resized)
+	go func() {
+		for range resized {
+			if rows, cols, err := getWinsize(os.Stdin); err == nil {
+				setWinsize(master, rows, cols)
+				if rec != nil {
+					rec.resize(rows, cols)
+				}
+			}
+		}
+	}()
+
+	go io.Copy(master, activityReader{os.Stdin, touch})
+	outputDone := make(chan struct{})
+	go func() {
+		io.Copy(activityWriter{output, touch}, master)
+		close(outputDone)
+	}()
+
+	err = cmd.Wait()
+
+	// Drain remaining output, without hanging on descendants holding the pty
+	select {
+	case <-outputDone:
+	case <-time.After(500 * time.Millisecond):
+	}
+	return err
+}
+
+func replay(args []string) {
+	speed := 1.0
+	maxIdle := 2.0
+	id := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := ""
+		if eq := strings.Index(arg, "="); eq >= 0 && strings.HasPrefix(arg, "-") {
+			arg, value = arg[:eq], arg[eq+1:]
+		} else if (arg == "--speed" || arg == "-s" || arg == "--max-idle") && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+
+		switch arg {
+		case "--speed", "-s":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v <= 0 {
+				log.Fatalf("\033[31m✗\033[0m Invalid speed: %s", value)
+			}
+			speed = v
+		case "--max-idle":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				log.Fatalf("\033[31m✗\033[0m Invalid max idle: %s", value)
+			}
+			maxIdle = v
+		default:
+			id = arg
+		}
+	}
+
+	var session *Session
+	var err error
+	if id != "" {
+		session, err = loadSession(id)
+	} else {
+		cwd, _ := os.Getwd()
+		session, err = latestSession(cwd)
+	}
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	if session.Recording == "" {
+		log.Fatalf("\033[31m✗\033[0m Session %s was not recorded", session.ID)
+	}
+
+	f, err := os.Open(session.Recording)
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to open recording:", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	if !scanner.Scan() {
+		log.Fatal("\033[31m✗\033[0m Empty recording")
+	}
+
+	fmt.Printf("\033[35m◉\033[0m Replaying %s at %gx\n", session.ID, speed)
+	last := 0.0
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			continue
+		}
+		at, _ := event[0].(float64)
+		kind, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if kind != "o" {
+			continue
+		}
+
+		delay := at - last
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		last = at
+		time.Sleep(time.Duration(delay / speed * float64(time.Second)))
+		os.Stdout.WriteString(data)
+	}
+	fmt.Println("\n\033[90m───────────────────────────────────\033[0m")
+	fmt.Printf("\033[32m✓\033[0m End of recording (%s)\n", filepath.Base(session.Recording))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readCast returns the header of an asciicast file and its events as kind
+// and data pairs.
+func readCast(t *testing.T, path string) (map[string]interface{}, [][2]string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("empty recording")
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	var events [][2]string
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("bad event %s", scanner.Bytes())
+		}
+		if _, ok := event[0].(float64); !ok {
+			t.Fatalf("event time %v isn't a number", event[0])
+		}
+		events = append(events, [2]string{event[1].(string), event[2].(string)})
+	}
+	return header, events
+}
+
+func TestCastRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	rec, err := newCastRecorder(path, 24, 80, "test session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := []byte("é")
+	rec.Write([]byte("hello "))
+	// A UTF-8 sequence split across writes is held back until it's complete
+	rec.Write(e[:1])
+	rec.Write(append(e[1:], '\n'))
+	rec.resize(30, 100)
+	rec.Write([]byte("bye"))
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header, events := readCast(t, path)
+	for key, want := range map[string]interface{}{"version": 2.0, "width": 80.0, "height": 24.0, "title": "test session"} {
+		if header[key] != want {
+			t.Errorf("header %s = %v, want %v", key, header[key], want)
+		}
+	}
+	// Output is flushed a line at a time
+	want := [][2]string{{"o", "hello é\n"}, {"r", "100x30"}, {"o", "bye"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events %q, want %q", events, want)
+	}
+}
+
+func TestCastRecorderRedaction(t *testing.T) {
+	registerSecret("hunter2secret")
+	defer func() { secretValues = nil }()
+	long := strings.Repeat("x", castLineLimit)
+
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"whole secret", []string{"token: hunter2secret\n"}, "token: ****\n"},
+		{"split across writes", []string{"token: hun", "ter2sec", "ret\n"}, "token: ****\n"},
+		{"split without a newline", []string{"token: hunter2", "secret"}, "token: ****"},
+		{"split on a long line", []string{long + "hunter2", "secret done"}, long + "**** done"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.cast")
+			rec, err := newCastRecorder(path, 24, 80, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				rec.Write([]byte(w))
+			}
+			rec.Close()
+
+			_, events := readCast(t, path)
+			var output string
+			for _, event := range events {
+				output += event[1]
+			}
+			if output != tt.want {
+				t.Errorf("recorded %q, want %q", output, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resources is the [resources] section. Unset fields fall back to the global
+// config; command line flags override both.
+type Resources struct {
+	CPUs        float64 `toml:"cpus"`
+	Memory      string  // e.g. "4g"
+	Swap        string  // memory plus swap, podman's --memory-swap; "-1" for unlimited
+	Pids        int     // overrides the security profile's pids_limit
+	Tmpfs       string  // size of each writable tmpfs, e.g. "512m"
+	Storage     string  // container filesystem size, needs a storage driver with quotas
+	MaxDuration string  `toml:"max_duration"` // wall-clock limit, e.g. "2h"
+	IdleTimeout string  `toml:"idle_timeout"` // stop after no terminal activity, e.g. "30m"
+}
+
+// Limits given on the command line for this run
+var resourceFlags Resources
+
+// resourceFlagNames maps command line flags to the setting they override.
+var resourceFlagNames = map[string]func(*Resources, string) error{
+	"--cpus": func(r *Resources, v string) (err error) {
+		r.CPUs, err = strconv.ParseFloat(v, 64)
+		return err
+	},
+	"--memory":       func(r *Resources, v string) error { r.Memory = v; return nil },
+	"--swap":         func(r *Resources, v string) error { r.Swap = v; return nil },
+	"--max-duration": func(r *Resources, v string) error { r.MaxDuration = v; return nil },
+	"--idle-timeout": func(r *Resources, v string) error { r.IdleTimeout = v; return nil },
+	"--pids": func(r *Resources, v string) (err error) {
+		r.Pids, err = strconv.Atoi(v)
+		return err
+	},
+}
+
+// parseResourceFlag consumes a resource flag ("--memory 4g" or "--memory=4g")
+// at args[i], returning how many arguments it used.
+func parseResourceFlag(args []string, i int) (int, error) {
+	name, value, hasValue := strings.Cut(args[i], "=")
+	set, ok := resourceFlagNames[name]
+	if !ok {
+		return 0, nil
+	}
+	used := 1
+	if !hasValue {
+		if i+1 >= len(args) {
+			return 0, fmt.Errorf("%s needs a value", name)
+		}
+		value = args[i+1]
+		used = 2
+	}
+	if err := set(&resourceFlags, value); err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return used, nil
+}
+
+// resolveResources layers global config, project config and command line flags.
+func resolveResources(config *Config, globalConfig *GlobalConfig) Resources {
+	resources := globalConfig.Resources
+	for _, layer := range []Resources{config.Resources, resourceFlags} {
+		if layer.CPUs != 0 {
+			resources.CPUs = layer.CPUs
+		}
+		if layer.Memory != "" {
+			resources.Memory = layer.Memory
+		}
+		if layer.Swap != "" {
+			resources.Swap = layer.Swap
+		}
+		if layer.Pids != 0 {
+			resources.Pids = layer.Pids
+		}
+		if layer.Tmpfs != "" {
+			resources.Tmpfs = layer.Tmpfs
+		}
+		if layer.Storage != "" {
+			resources.Storage = layer.Storage
+		}
+		if layer.MaxDuration != "" {
+			resources.MaxDuration = layer.MaxDuration
+		}
+		if layer.IdleTimeout != "" {
+			resources.IdleTimeout = layer.IdleTimeout
+		}
+	}
+	return resources
+}
+
+func parseLimit(name, value string) (time.Duration, error) {
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q, use a duration like \"90m\" or \"2h\"", name, value)
+	}
+	return d, nil
+}
+
+func (r Resources) timeouts() (maxDuration, idleTimeout time.Duration, err error) {
+	if maxDuration, err = parseLimit("max_duration", r.MaxDuration); err != nil {
+		return 0, 0, err
+	}
+	idleTimeout, err = parseLimit("idle_timeout", r.IdleTimeout)
+	return maxDuration, idleTimeout, err
+}
+
+func (r Resources) args() []string {
+	var args []string
+	if r.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(r.CPUs, 'f', -1, 64))
+	}
+	if r.Memory != "" {
+		args = append(args, "--memory", r.Memory)
+	}
+	if r.Swap != "" {
+		args = append(args, "--memory-swap", r.Swap)
+	}
+	if r.Storage != "" {
+		args = append(args, "--storage-opt", "size="+r.Storage)
+	}
+	return args
+}
+
+// watchdog stops a session's container once it runs too long or sits idle.
+// stop hands back why, for the session.
+type watchdog struct {
+	session     *Session
+	maxDuration time.Duration
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	reason   string
+	done     chan struct{}
+	exited   chan struct{}
+}
+
+// startWatchdog returns nil when the session has no time limits.
+func startWatchdog(session *Session, resources Resources) (*watchdog, error) {
+	maxDuration, idleTimeout, err := resources.timeouts()
+	if err != nil {
+		return nil, err
+	}
+	if maxDuration == 0 && idleTimeout == 0 {
+		return nil, nil
+	}
+
+	w := &watchdog{
+		session:     session,
+		maxDuration: maxDuration,
+		idleTimeout: idleTimeout,
+		lastSeen:    time.Now(),
+		done:        make(chan struct{}),
+		exited:      make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// touch records terminal activity.
+func (w *watchdog) touch() {
+	w.mu.Lock()
+	w.lastSeen = time.Now()
+	w.mu.Unlock()
+}
+
+// stop ends the watchdog and returns why it stopped the container, if it did.
+func (w *watchdog) stop() string {
+	close(w.done)
+	<-w.exited
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reason
+}
+
+func (w *watchdog) run() {
+	defer close(w.exited)
+	started := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		idle := time.Since(w.lastSeen)
+		w.mu.Unlock()
+
+		reason := ""
+		switch {
+		case w.maxDuration > 0 && time.Since(started) >= w.maxDuration:
+			reason = "max_duration " + w.maxDuration.String() + " reached"
+		case w.idleTimeout > 0 && idle >= w.idleTimeout:
+			reason = "idle for " + w.idleTimeout.String()
+		}
+		if reason != "" {
+			w.mu.Lock()
+			w.reason = reason
+			w.mu.Unlock()
+			fmt.Fprintf(os.Stderr, "\r\n\033[33m⚠\033[0m  Stopping session: %s\r\n", reason)
+			exec.Command("podman", "stop", "-t", "10", w.session.Container).Run()
+			return
+		}
+	}
+}
+
+// activityWriter and activityReader pass terminal traffic through and
+// report it to the watchdog.
+type activityWriter struct {
+	w     io.Writer
+	touch func()
+}
+
+func (a activityWriter) Write(p []byte) (int, error) {
+	a.touch()
+	return a.w.Write(p)
+}
+
+type activityReader struct {
+	r     io.Reader
+	touch func()
+}
+
+func (a activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.touch()
+	}
+	return n, err
+}
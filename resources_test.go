@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseResourceFlag(t *testing.T) {
+	tests := []struct {
+		args    []string
+		used    int
+		want    Resources
+		wantErr bool
+	}{
+		{[]string{"--cpus", "2.5"}, 2, Resources{CPUs: 2.5}, false},
+		{[]string{"--memory=4g"}, 1, Resources{Memory: "4g"}, false},
+		{[]string{"--swap", "-1"}, 2, Resources{Swap: "-1"}, false},
+		{[]string{"--pids=512"}, 1, Resources{Pids: 512}, false},
+		{[]string{"--max-duration", "2h", "claude"}, 2, Resources{MaxDuration: "2h"}, false},
+		{[]string{"--idle-timeout=30m"}, 1, Resources{IdleTimeout: "30m"}, false},
+		{[]string{"--verbose"}, 0, Resources{}, false},
+		{[]string{"-v"}, 0, Resources{}, false},
+		{[]string{"--memory"}, 0, Resources{}, true},
+		{[]string{"--cpus", "lots"}, 0, Resources{}, true},
+		{[]string{"--pids=many"}, 0, Resources{}, true},
+	}
+	for _, tt := range tests {
+		resourceFlags = Resources{}
+		used, err := parseResourceFlag(tt.args, 0)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: err = %v, want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (used != tt.used || resourceFlags != tt.want) {
+			t.Errorf("%v: used %d, set %+v, want %d, %+v", tt.args, used, resourceFlags, tt.used, tt.want)
+		}
+	}
+	resourceFlags = Resources{}
+}
+
+func TestResolveResources(t *testing.T) {
+	globalConfig := &GlobalConfig{}
+	globalConfig.Resources = Resources{CPUs: 1, Memory: "2g", IdleTimeout: "1h"}
+	config := &Config{}
+	config.Resources = Resources{Memory: "8g", MaxDuration: "4h"}
+	resourceFlags = Resources{CPUs: 4, MaxDuration: "30m"}
+	defer func() { resourceFlags = Resources{} }()
+
+	want := Resources{CPUs: 4, Memory: "8g", IdleTimeout: "1h", MaxDuration: "30m"}
+	if got := resolveResources(config, globalConfig); got != want {
+		t.Errorf("resolved %+v, want %+v", got, want)
+	}
+}
+
+func TestResourcesArgs(t *testing.T) {
+	tests := []struct {
+		resources Resources
+		want      []string
+	}{
+		{Resources{}, nil},
+		{Resources{CPUs: 1.5, Memory: "4g"}, []string{"--cpus", "1.5", "--memory", "4g"}},
+		{Resources{Swap: "-1", Storage: "20G"}, []string{"--memory-swap", "-1", "--storage-opt", "size=20G"}},
+		// Limits enforced by viber00t itself or by the security profile
+		{Resources{Pids: 100, Tmpfs: "1g", MaxDuration: "1h", IdleTimeout: "5m"}, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.resources.args(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: args %v, want %v", tt.resources, got, tt.want)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"90m", 90 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"2", 0, true},
+		{"-1h", 0, true},
+		{"forever", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseLimit("max_duration", tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseLimit(%q) = %v, %v, want %v (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWatchdogStopReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources Resources
+		want      string
+	}{
+		{"no limits", Resources{}, ""},
+		{"not reached", Resources{MaxDuration: "1h", IdleTimeout: "1h"}, ""},
+		{"max duration", Resources{MaxDuration: "1ms"}, "max_duration 1ms reached"},
+		{"idle", Resources{IdleTimeout: "1ms"}, "idle for 1ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			session := &Session{Container: "viber00t-test-does-not-exist"}
+			dog, err := startWatchdog(session, tt.resources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dog == nil {
+				if tt.want != "" {
+					t.Fatal("no watchdog started")
+				}
+				return
+			}
+			// The watchdog checks once a second
+			time.Sleep(1500 * time.Millisecond)
+			if got := dog.stop(); got != tt.want {
+				t.Errorf("stop reason %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
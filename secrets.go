@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecretSpec is a [[secrets]] entry: where the value comes from on the host
+// and how the container receives it.
+type SecretSpec struct {
+	Name        string
+	FromEnv     string `toml:"from_env"`     // host environment variable
+	FromFile    string `toml:"from_file"`    // host file
+	FromPass    string `toml:"from_pass"`    // entry in the pass password store
+	FromCommand string `toml:"from_command"` // stdout of a host command
+	As          string // "env" (default) or "file" (/run/secrets/<name>)
+	Variable    string // variable name inside the container, default NAME
+}
+
+// Secret values resolved during this run, redacted from everything viber00t writes
+var (
+	secretsMu    sync.Mutex
+	secretValues []string
+)
+
+func registerSecret(value string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secretValues = append(secretValues, value)
+	// Longest first so a secret containing another is fully masked
+	sort.Slice(secretValues, func(i, j int) bool { return len(secretValues[i]) > len(secretValues[j]) })
+}
+
+// redact masks every known secret value in s.
+func redact(s string) string {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, value := range secretValues {
+		if len(value) >= 4 {
+			s = strings.ReplaceAll(s, value, "****")
+		}
+	}
+	return s
+}
+
+// longestSecret is the length of the longest known secret value.
+func longestSecret() int {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if len(secretValues) == 0 {
+		return 0
+	}
+	return len(secretValues[0])
+}
+
+func redactAll(values []string) []string {
+	redacted := make([]string, len(values))
+	for i, value := range values {
+		redacted[i] = redact(value)
+	}
+	return redacted
+}
+
+func (s SecretSpec) source() string {
+	switch {
+	case s.FromEnv != "":
+		return "env " + s.FromEnv
+	case s.FromFile != "":
+		return "file " + s.FromFile
+	case s.FromPass != "":
+		return "pass " + s.FromPass
+	case s.FromCommand != "":
+		return "command " + s.FromCommand
+	}
+	return "nothing"
+}
+
+func (s SecretSpec) variable() string {
+	if s.Variable != "" {
+		return s.Variable
+	}
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(s.Name))
+}
+
+// destination describes where the secret shows up inside the container.
+func (s SecretSpec) destination() string {
+	if s.As == "file" {
+		return "/run/secrets/" + s.Name
+	}
+	return "$" + s.variable()
+}
+
+// declaredSecrets drops the empty entries left by commented-out [[secrets]] tables.
+func declaredSecrets(specs []SecretSpec) []SecretSpec {
+	var declared []SecretSpec
+	for _, spec := range specs {
+		if spec != (SecretSpec{}) {
+			declared = append(declared, spec)
+		}
+	}
+	return declared
+}
+
+func checkSecrets(specs []SecretSpec) error {
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		if spec.Name == "" {
+			return errors.New("[[secrets]] entry without a name")
+		}
+		if seen[spec.Name] {
+			return fmt.Errorf("secret %q declared twice", spec.Name)
+		}
+		seen[spec.Name] = true
+
+		sources := 0
+		for _, source := range []string{spec.FromEnv, spec.FromFile, spec.FromPass, spec.FromCommand} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("secret %q needs exactly one of from_env, from_file, from_pass or from_command", spec.Name)
+		}
+		if spec.As != "" && spec.As != "env" && spec.As != "file" {
+			return fmt.Errorf("secret %q: as must be \"env\" or \"file\"", spec.Name)
+		}
+	}
+	return nil
+}
+
+func resolveSecret(spec SecretSpec) (string, error) {
+	var value string
+	switch {
+	case spec.FromEnv != "":
+		value = os.Getenv(spec.FromEnv)
+		if value == "" {
+			return "", fmt.Errorf("secret %q: $%s is not set", spec.Name, spec.FromEnv)
+		}
+	case spec.FromFile != "":
+		data, err := ioutil.ReadFile(expandPath(spec.FromFile))
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %w", spec.Name, err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	case spec.FromPass != "":
+		out, err := exec.Command("pass", "show", spec.FromPass).Output()
+		if err != nil {
+			return "", fmt.Errorf("secret %q: pass show %s failed", spec.Name, spec.FromPass)
+		}
+		// pass keeps the password on the first line
+		value = strings.SplitN(string(out), "\n", 2)[0]
+	case spec.FromCommand != "":
+		cmd := exec.Command("sh", "-c", spec.FromCommand)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("secret %q: command failed: %w", spec.Name, err)
+		}
+		value = strings.TrimRight(string(out), "\r\n")
+	}
+	if value == "" {
+		return "", fmt.Errorf("secret %q is empty", spec.Name)
+	}
+	return value, nil
+}
+
+// setupSecrets resolves every secret, stores it as a podman secret for the
+// lifetime of the session and returns the matching run arguments. Values only
+// ever travel over stdin, never through arguments, files or the image.
+func setupSecrets(specs []SecretSpec, session *Session, dryRun bool) ([]string, error) {
+	specs = declaredSecrets(specs)
+	if err := checkSecrets(specs); err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for _, spec := range specs {
+		podmanName := fmt.Sprintf("viber00t-%s-%s", session.ID, spec.Name)
+		if spec.As == "file" {
+			args = append(args, "--secret", fmt.Sprintf("%s,type=mount,target=%s", podmanName, spec.Name))
+		} else {
+			args = append(args, "--secret", fmt.Sprintf("%s,type=env,target=%s", podmanName, spec.variable()))
+		}
+		if dryRun {
+			continue
+		}
+
+		value, err := resolveSecret(spec)
+		if err != nil {
+			return nil, err
+		}
+		registerSecret(value)
+
+		exec.Command("podman", "secret", "rm", podmanName).Run()
+		cmd := exec.Command("podman", "secret", "create", podmanName, "-")
+		cmd.Stdin = bytes.NewReader([]byte(value))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("secret %q: podman secret create failed: %s", spec.Name, redact(strings.TrimSpace(string(out))))
+		}
+		session.onFinish(func() {
+			exec.Command("podman", "secret", "rm", podmanName).Run()
+		})
+	}
+	return args, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SecurityPolicy is the [security] section: a named profile plus overrides.
+type SecurityPolicy struct {
+	Profile         string   // "strict" (default), "default" or "privileged"
+	CapAdd          []string `toml:"cap_add"`           // added to the profile's capabilities
+	CapDrop         []string `toml:"cap_drop"`          // dropped on top of the profile
+	NoNewPrivileges *bool    `toml:"no_new_privileges"` // block setuid escalation
+	Seccomp         string   // custom seccomp profile, default podman's
+	ReadOnly        *bool    `toml:"read_only"` // read-only root filesystem
+	Tmpfs           []string // extra writable tmpfs paths when read-only
+	PidsLimit       int      `toml:"pids_limit"` // -1 for unlimited
+}
+
+// securityProfile is a fully resolved set of container restrictions.
+type securityProfile struct {
+	Name            string
+	Privileged      bool
+	CapDrop         []string
+	CapAdd          []string
+	NoNewPrivileges bool
+	Seccomp         string
+	ReadOnly        bool
+	Tmpfs           []string
+	TmpfsSize       string
+	PidsLimit       int
+}
+
+// What has to stay writable on a read-only root filesystem: scratch space and
+// the whole home (agent state, ~/go, pip --user), seeded from the image
+var agentStateTmpfs = []string{"/tmp", "/var/tmp", "/root"}
+
+var securityProfiles = map[string]securityProfile{
+	// Only what a root shell needs to work on the project
+	"strict": {
+		CapDrop:         []string{"ALL"},
+		CapAdd:          []string{"CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "SETGID", "SETUID", "NET_BIND_SERVICE"},
+		NoNewPrivileges: true,
+		ReadOnly:        true,
+		Tmpfs:           agentStateTmpfs,
+		PidsLimit:       1024,
+	},
+	// Podman's default capabilities, writable image for package installs
+	"default": {
+		NoNewPrivileges: true,
+		PidsLimit:       4096,
+	},
+	// Full access, including the docker socket
+	"privileged": {
+		Privileged: true,
+	},
+}
+
+func checkSecurityPolicy(policy SecurityPolicy, origin string) error {
+	if _, ok := securityProfiles[policy.Profile]; policy.Profile != "" && !ok {
+		return fmt.Errorf("%s: unknown security profile %q (strict, default or privileged)", origin, policy.Profile)
+	}
+	return nil
+}
+
+// resolveSecurity picks the profile (project, then privileged = true, then the
+// global default, then strict) and applies global and project overrides.
+func resolveSecurity(config *Config, globalConfig *GlobalConfig) securityProfile {
+	name := "strict"
+	switch {
+	case config.Security.Profile != "":
+		name = config.Security.Profile
+	case config.Project.Privileged:
+		name = "privileged"
+	case globalConfig.Security.Profile != "":
+		name = globalConfig.Security.Profile
+	}
+
+	profile := securityProfiles[name]
+	profile.Name = name
+	// Copy so overrides never touch the shared profile definitions
+	profile.CapDrop = append([]string(nil), profile.CapDrop...)
+	profile.CapAdd = append([]string(nil), profile.CapAdd...)
+	profile.Tmpfs = append([]string(nil), profile.Tmpfs...)
+
+	for _, policy := range []SecurityPolicy{globalConfig.Security, config.Security} {
+		profile.CapAdd = append(profile.CapAdd, policy.CapAdd...)
+		profile.CapDrop = append(profile.CapDrop, policy.CapDrop...)
+		profile.Tmpfs = append(profile.Tmpfs, policy.Tmpfs...)
+		if policy.NoNewPrivileges != nil {
+			profile.NoNewPrivileges = *policy.NoNewPrivileges
+		}
+		if policy.Seccomp != "" {
+			profile.Seccomp = expandPath(policy.Seccomp)
+		}
+		if policy.ReadOnly != nil {
+			profile.ReadOnly = *policy.ReadOnly
+		}
+		if policy.PidsLimit != 0 {
+			profile.PidsLimit = policy.PidsLimit
+		}
+	}
+
+	// A non-root user gets sudo, which no-new-privileges would make useless;
+	// it is no less contained than the root default. Setting it explicitly wins.
+	u := resolveUser(config, globalConfig)
+	if !u.isRoot() && globalConfig.Security.NoNewPrivileges == nil && config.Security.NoNewPrivileges == nil {
+		profile.NoNewPrivileges = false
+	}
+
+	// Agent state lives in the container user's home
+	for i, path := range profile.Tmpfs {
+		profile.Tmpfs[i] = u.retarget(path)
+	}
+	return profile
+}
+
+func (p securityProfile) args() []string {
+	if p.Privileged {
+		return []string{"--privileged", "--security-opt", "label=disable"}
+	}
+
+	var args []string
+	for _, capability := range p.CapDrop {
+		args = append(args, "--cap-drop", strings.ToUpper(capability))
+	}
+	for _, capability := range p.CapAdd {
+		args = append(args, "--cap-add", strings.ToUpper(capability))
+	}
+	if p.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	if p.Seccomp != "" {
+		args = append(args, "--security-opt", "seccomp="+p.Seccomp)
+	}
+	if p.ReadOnly {
+		args = append(args, "--read-only")
+		for _, path := range p.Tmpfs {
+			args = append(args, "--tmpfs", tmpfsArg(path, p.TmpfsSize))
+		}
+	}
+	if p.PidsLimit != 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(p.PidsLimit))
+	}
+	return args
+}
+
+// tmpfsArg mounts path executable, podman's default is noexec and breaks
+// go test, go run and anything else run from /tmp. Paths given with their own
+// options are left alone.
+func tmpfsArg(path, size string) string {
+	if strings.Contains(path, ":") {
+		return path
+	}
+	options := "rw,exec,nosuid,nodev"
+	if path == "/tmp" || path == "/var/tmp" {
+		options += ",mode=1777"
+	}
+	if size != "" {
+		options += ",size=" + size
+	}
+	return path + ":" + options
+}
+
+// describe summarizes the project's [security] overrides for the trust prompt.
+func (p SecurityPolicy) describe() string {
+	var parts []string
+	if p.Profile != "" {
+		parts = append(parts, "profile = "+p.Profile)
+	}
+	if len(p.CapAdd) > 0 {
+		parts = append(parts, "cap_add = ["+strings.Join(p.CapAdd, ", ")+"]")
+	}
+	if len(p.CapDrop) > 0 {
+		parts = append(parts, "cap_drop = ["+strings.Join(p.CapDrop, ", ")+"]")
+	}
+	parts = append(parts, describeBool("no_new_privileges", p.NoNewPrivileges), describeBool("read_only", p.ReadOnly))
+	if p.Seccomp != "" {
+		parts = append(parts, "seccomp = "+p.Seccomp)
+	}
+	if len(p.Tmpfs) > 0 {
+		parts = append(parts, "tmpfs = ["+strings.Join(p.Tmpfs, ", ")+"]")
+	}
+	if p.PidsLimit != 0 {
+		parts = append(parts, "pids_limit = "+strconv.Itoa(p.PidsLimit))
+	}
+	return joinSettings(parts...)
+}
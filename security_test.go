@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveSecurity(t *testing.T) {
+	no := false
+	tests := []struct {
+		name     string
+		project  string // Viber00t.toml
+		global   SecurityPolicy
+		profile  string
+		nnp      bool
+		readOnly bool
+		capAdd   []string
+	}{
+		{name: "strict by default", profile: "strict", nnp: true, readOnly: true},
+		{name: "privileged = true", project: "[project]\nprivileged = true\n", profile: "privileged"},
+		{name: "global default", global: SecurityPolicy{Profile: "default"}, profile: "default", nnp: true},
+		{name: "project wins", project: "[security]\nprofile = \"strict\"\n", global: SecurityPolicy{Profile: "default"}, profile: "strict", nnp: true, readOnly: true},
+		{
+			name:     "overrides",
+			project:  "[security]\ncap_add = [\"SYS_PTRACE\"]\nread_only = false\n",
+			global:   SecurityPolicy{CapAdd: []string{"NET_RAW"}, NoNewPrivileges: &no},
+			profile:  "strict",
+			capAdd:   []string{"NET_RAW", "SYS_PTRACE"},
+			readOnly: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			globalConfig := &GlobalConfig{}
+			globalConfig.Security = tt.global
+			profile := resolveSecurity(decodeConfig(t, tt.project), globalConfig)
+			if profile.Name != tt.profile || profile.NoNewPrivileges != tt.nnp || profile.ReadOnly != tt.readOnly {
+				t.Errorf("got %s (no_new_privileges %v, read_only %v), want %s (%v, %v)",
+					profile.Name, profile.NoNewPrivileges, profile.ReadOnly, tt.profile, tt.nnp, tt.readOnly)
+			}
+			if tt.capAdd != nil {
+				added := profile.CapAdd[len(profile.CapAdd)-len(tt.capAdd):]
+				if !reflect.DeepEqual(added, tt.capAdd) {
+					t.Errorf("cap_add ends with %v, want %v", added, tt.capAdd)
+				}
+			}
+		})
+	}
+
+	// Overrides must not leak into the shared profile definitions
+	if len(securityProfiles["strict"].CapAdd) != 8 {
+		t.Errorf("strict profile changed: %v", securityProfiles["strict"].CapAdd)
+	}
+}
+
+func TestSecurityProfileArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile securityProfile
+		want    []string
+	}{
+		{"privileged", securityProfile{Privileged: true, CapDrop: []string{"ALL"}}, []string{"--privileged", "--security-opt", "label=disable"}},
+		{"empty", securityProfile{}, nil},
+		{
+			"restricted",
+			securityProfile{
+				CapDrop:         []string{"ALL"},
+				CapAdd:          []string{"chown"},
+				NoNewPrivileges: true,
+				Seccomp:         "/etc/seccomp.json",
+				ReadOnly:        true,
+				Tmpfs:           []string{"/tmp"},
+				PidsLimit:       100,
+			},
+			[]string{
+				"--cap-drop", "ALL", "--cap-add", "CHOWN",
+				"--security-opt", "no-new-privileges", "--security-opt", "seccomp=/etc/seccomp.json",
+				"--read-only", "--tmpfs", "/tmp:rw,exec,nosuid,nodev,mode=1777", "--pids-limit", "100",
+			},
+		},
+		// Tmpfs only matters for a read-only root filesystem
+		{"writable", securityProfile{Tmpfs: []string{"/tmp"}}, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.args(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: args %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTmpfsArg(t *testing.T) {
+	tests := []struct {
+		path, size, want string
+	}{
+		{"/tmp", "", "/tmp:rw,exec,nosuid,nodev,mode=1777"},
+		{"/root", "", "/root:rw,exec,nosuid,nodev"},
+		{"/var/tmp", "1g", "/var/tmp:rw,exec,nosuid,nodev,mode=1777,size=1g"},
+		// Paths with their own options are left alone
+		{"/cache:noexec,size=64m", "1g", "/cache:noexec,size=64m"},
+	}
+	for _, tt := range tests {
+		if got := tmpfsArg(tt.path, tt.size); got != tt.want {
+			t.Errorf("tmpfsArg(%q, %q) = %q, want %q", tt.path, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSecurityPolicy(t *testing.T) {
+	for _, profile := range []string{"", "strict", "default", "privileged"} {
+		if err := checkSecurityPolicy(SecurityPolicy{Profile: profile}, "test"); err != nil {
+			t.Errorf("%q: %v", profile, err)
+		}
+	}
+	if err := checkSecurityPolicy(SecurityPolicy{Profile: "paranoid"}, "test"); err == nil {
+		t.Errorf("unknown profile accepted")
+	}
+}
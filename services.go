@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Service is a [[services]] sidecar (database, cache, ...) started next to
+// the project container on a shared network and reachable by its name.
+type Service struct {
+	Name          string
+	Image         string
+	Command       []string          // overrides the image's command
+	Env           map[string]string // e.g. { POSTGRES_PASSWORD = "dev" }
+	Ports         []PortMapping     // published on the host, like [[ports]]
+	Volumes       []ServiceVolume
+	Healthcheck   string       // command run inside the service; the session waits until it passes
+	HealthTimeout string       `toml:"health_timeout"` // default 60s
+	Ready         []ReadyCheck // checked from the project container before the agent starts
+}
+
+// ServiceVolume mounts a host path (absolute, ./relative or ~/) or a named
+// volume, kept per project, into a service.
+type ServiceVolume struct {
+	Source string
+	Target string
+}
+
+const defaultHealthTimeout = 60 * time.Second
+
+// declaredServices skips the empty [[services]] table of the init template.
+func declaredServices(config *Config) []Service {
+	var services []Service
+	for _, service := range config.Services {
+		if service.Name != "" || service.Image != "" {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+func checkServices(services []Service) error {
+	seen := make(map[string]bool)
+	for _, service := range services {
+		if service.Name == "" || service.Image == "" {
+			return fmt.Errorf("Viber00t.toml: every [[services]] entry needs a name and an image")
+		}
+		if hostLabel(service.Name) != service.Name {
+			return fmt.Errorf("Viber00t.toml: service name %q must be lowercase letters, digits and dashes", service.Name)
+		}
+		if seen[service.Name] {
+			return fmt.Errorf("Viber00t.toml: service %q is declared twice", service.Name)
+		}
+		seen[service.Name] = true
+		if _, err := parseLimit("health_timeout", service.HealthTimeout); err != nil {
+			return fmt.Errorf("Viber00t.toml: service %s: %w", service.Name, err)
+		}
+		if err := checkPorts(service.Ports); err != nil {
+			return fmt.Errorf("%w (service %s)", err, service.Name)
+		}
+		for _, vol := range service.Volumes {
+			if vol.Source == "" || vol.Target == "" {
+				return fmt.Errorf("Viber00t.toml: service %s: volumes need a source and a target", service.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// serviceNetwork is the project's network. Without full network access it is
+// internal: services and the project container see each other, nothing else.
+func serviceNetwork(config *Config, mode string) (name string, internal bool) {
+	name = "viber00t-" + hostLabel(config.Project.Name)
+	if mode != "full" {
+		return name + "-internal", true
+	}
+	return name, false
+}
+
+func serviceContainer(config *Config, service Service) string {
+	return fmt.Sprintf("viber00t-%s-%s", hostLabel(config.Project.Name), service.Name)
+}
+
+// isHostPath tells host paths from named volumes, as podman does.
+func isHostPath(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
+
+func (v ServiceVolume) arg(config *Config, cwd string) string {
+	source := expandPath(v.Source)
+	if !isHostPath(v.Source) {
+		// Named volumes are per project so two projects' "data" never mix
+		source = "viber00t-" + hostLabel(config.Project.Name) + "-" + v.Source
+	} else if !filepath.IsAbs(source) {
+		source = filepath.Join(cwd, source)
+	}
+	return source + ":" + v.Target + ":Z"
+}
+
+// describe is the service as shown in the trust prompt: the image and
+// whatever it reaches on the host.
+func (s Service) describe() string {
+	line := fmt.Sprintf("service: %s (%s)", s.Name, s.Image)
+	var extras []string
+	for _, port := range s.Ports {
+		if port.declared() {
+			extras = append(extras, strings.TrimPrefix(port.describe(), "port: "))
+		}
+	}
+	for _, vol := range s.Volumes {
+		if isHostPath(vol.Source) {
+			extras = append(extras, fmt.Sprintf("%s → %s (rw)", vol.Source, vol.Target))
+		}
+	}
+	if len(extras) > 0 {
+		line += ": " + strings.Join(extras, ", ")
+	}
+	return line
+}
+
+// runArgs is the podman run command for the service. The config hash label
+// lets a later session reuse a running service only if nothing changed, the
+// path label tells checkouts of the same project apart.
+func (s Service) runArgs(config *Config, cwd, network string, ports []portBinding) []string {
+	args := []string{
+		"run", "-d",
+		"--name", serviceContainer(config, s),
+		"--network", network,
+		"--network-alias", s.Name,
+		"--label", "viber00t.project=" + config.Project.Name,
+		"--label", "viber00t.service=" + s.Name,
+		"--label", "viber00t.path=" + cwd,
+	}
+	keys := make([]string, 0, len(s.Env))
+	for key := range s.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-e", key+"="+s.Env[key])
+	}
+	for _, port := range ports {
+		args = append(args, "-p", port.arg())
+	}
+	for _, vol := range s.Volumes {
+		args = append(args, "-v", vol.arg(config, cwd))
+	}
+	if s.Healthcheck != "" {
+		args = append(args, "--health-cmd", s.Healthcheck, "--health-interval", "5s")
+	}
+
+	args = append(args, "--label", "viber00t.config="+s.hash(config, cwd, network))
+	args = append(args, s.Image)
+	return append(args, s.Command...)
+}
+
+// hash identifies the service's configuration, before "auto" ports are picked.
+func (s Service) hash(config *Config, cwd, network string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%+v", config.Project.Name, cwd, network, s)))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// startServices brings up the project's services and returns the network the
+// project container joins, and the ports the services published. Services are
+// shared by every session of the project and stopped with the last one.
+func startServices(config *Config, networkPolicy NetworkPolicy, session *Session, cwd string) (string, []portBinding, error) {
+	services := declaredServices(config)
+	if len(services) == 0 {
+		return "", nil, nil
+	}
+	if err := checkServices(services); err != nil {
+		return "", nil, err
+	}
+	network, internal := serviceNetwork(config, networkPolicy.Mode)
+
+	var commands [][]string
+	if !dryRun {
+		if err := ensureNetwork(network, internal, "viber00t.project="+config.Project.Name); err != nil {
+			return "", nil, err
+		}
+		// Cleanups run last first: the network goes after its services
+		session.onFinish(func() {
+			if !servicesInUse(config) {
+				exec.Command("podman", "network", "rm", network).Run()
+			}
+		})
+	}
+
+	var published []portBinding
+	for _, service := range services {
+		name := serviceContainer(config, service)
+		if !dryRun && serviceRunning(name, service.hash(config, cwd, network)) {
+			// Another session started it, its ports are already bound
+			fmt.Printf("\033[35m◉\033[0m Service %s already running\n", service.Name)
+			session.onFinish(func() { stopService(config, name) })
+			published = append(published, servicePorts(name, service.Name)...)
+			continue
+		}
+
+		var ports []portBinding
+		if !internal {
+			bindings, err := resolvePorts(service.Ports)
+			if err != nil {
+				return "", nil, fmt.Errorf("%w (service %s)", err, service.Name)
+			}
+			ports = bindings
+		} else if len(declaredPorts(service.Ports)) > 0 {
+			fmt.Printf("\033[33m⚠\033[0m  Ports of service %s not published, network mode %q has no host network\n", service.Name, networkPolicy.Mode)
+		}
+		runArgs := service.runArgs(config, cwd, network, ports)
+		if dryRun {
+			commands = append(commands, runArgs)
+			continue
+		}
+
+		// Replace a stale container of this checkout, never another one's
+		if path, exists := serviceCheckout(name); exists && path != cwd {
+			return "", nil, fmt.Errorf("service %s is running for %s (container %s), stop it there first", service.Name, path, name)
+		}
+		exec.Command("podman", "rm", "-f", name).Run()
+		fmt.Printf("\033[35m◉\033[0m Starting service \033[36m%s\033[0m (%s)\n", service.Name, service.Image)
+		output, err := exec.Command("podman", runArgs...).CombinedOutput()
+		// Stopped with the session, even when a later service fails to start
+		session.onFinish(func() { stopService(config, name) })
+		if err != nil {
+			return "", nil, fmt.Errorf("service %s failed to start: %s", service.Name, strings.TrimSpace(string(output)))
+		}
+		for _, port := range ports {
+			if port.Auto {
+				fmt.Printf("\033[35m◉\033[0m Port %s (%s)\n", port, service.Name)
+			}
+			if port.Name == "" {
+				port.Name = service.Name
+			}
+			published = append(published, port)
+		}
+	}
+	for _, port := range published {
+		session.Ports = append(session.Ports, port.Name+" "+port.String())
+	}
+
+	if dryRun {
+		for _, command := range commands {
+			fmt.Println(quoteCommand("podman", command))
+		}
+		return network, published, nil
+	}
+
+	for _, service := range services {
+		if err := waitHealthy(config, service); err != nil {
+			return "", nil, err
+		}
+	}
+	return network, published, nil
+}
+
+// serviceLabels mark the project container so services know who still uses them.
+func serviceLabels(config *Config) []string {
+	return []string{"--label", "viber00t.project=" + config.Project.Name, "--label", "viber00t.role=dev"}
+}
+
+// serviceRunning reports whether the service runs with the same configuration.
+func serviceRunning(name, hash string) bool {
+	output, err := exec.Command("podman", "container", "inspect", "--format",
+		`{{.State.Running}} {{index .Config.Labels "viber00t.config"}}`, name).Output()
+	return err == nil && strings.TrimSpace(string(output)) == "true "+hash
+}
+
+// serviceCheckout returns the project path a service container was started
+// for, and whether the container exists at all.
+func serviceCheckout(name string) (string, bool) {
+	output, err := exec.Command("podman", "container", "inspect", "--format",
+		`{{index .Config.Labels "viber00t.path"}}`, name).Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(output)), true
+}
+
+// servicePorts reads the ports a running service has published.
+func servicePorts(container, service string) []portBinding {
+	output, err := exec.Command("podman", "port", container).Output()
+	if err != nil {
+		return nil
+	}
+	var ports []portBinding
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		// "5432/tcp -> 127.0.0.1:49153"
+		containerPort, hostAddress, ok := strings.Cut(line, " -> ")
+		number, protocol, _ := strings.Cut(containerPort, "/")
+		host, hostPort, err := net.SplitHostPort(hostAddress)
+		if !ok || err != nil {
+			continue
+		}
+		var c, h portSpec
+		if c.UnmarshalTOML(number) != nil || h.UnmarshalTOML(hostPort) != nil {
+			continue
+		}
+		ports = append(ports, portBinding{Name: service, HostIP: host, Host: h, Container: c, Protocol: protocol})
+	}
+	return ports
+}
+
+// waitHealthy blocks until the service's healthcheck passes.
+func waitHealthy(config *Config, service Service) error {
+	if service.Healthcheck == "" {
+		return nil
+	}
+	timeout, _ := parseLimit("health_timeout", service.HealthTimeout)
+	if timeout == 0 {
+		timeout = defaultHealthTimeout
+	}
+
+	name := serviceContainer(config, service)
+	fmt.Printf("\033[35m◉\033[0m Waiting for %s", service.Name)
+	deadline := time.Now().Add(timeout)
+	for {
+		if exec.Command("podman", "healthcheck", "run", name).Run() == nil {
+			fmt.Println(" \033[32m✓\033[0m")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			fmt.Println(" \033[31m✗\033[0m")
+			logs, _ := exec.Command("podman", "logs", "--tail", "20", name).CombinedOutput()
+			return fmt.Errorf("service %s not healthy after %s (%s)\n%s", service.Name, timeout, service.Healthcheck, logs)
+		}
+		fmt.Print(".")
+		time.Sleep(time.Second)
+	}
+}
+
+// servicesInUse reports whether another session of the project still runs.
+func servicesInUse(config *Config) bool {
+	output, _ := exec.Command("podman", "ps", "-q",
+		"--filter", "label=viber00t.project="+config.Project.Name,
+		"--filter", "label=viber00t.role=dev").Output()
+	return strings.TrimSpace(string(output)) != ""
+}
+
+// stopService removes a service container unless another session of the
+// project still uses it.
+func stopService(config *Config, container string) {
+	if !servicesInUse(config) {
+		exec.Command("podman", "rm", "-f", "-t", "5", container).Run()
+	}
+}
+
+// showServices prints the state of the project's services.
+func showServices() {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+		os.Exit(1)
+	}
+	services := declaredServices(config)
+	if len(services) == 0 {
+		fmt.Println("\033[90mNo [[services]] in Viber00t.toml\033[0m")
+		return
+	}
+	for _, service := range services {
+		output, err := exec.Command("podman", "container", "inspect", "--format",
+			"{{.State.Status}} {{.State.Health.Status}}", serviceContainer(config, service)).Output()
+		status := "stopped"
+		if err == nil {
+			status = strings.TrimSpace(string(output))
+		}
+		fmt.Printf("  \033[36m%-16s\033[0m %-24s \033[90m%s\033[0m\n", service.Name, service.Image, status)
+	}
+}
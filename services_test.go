@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStartServicesOtherCheckout(t *testing.T) {
+	cwd := t.TempDir()
+	tests := []struct {
+		name    string
+		owner   string // viber00t.path label of the existing container, empty for none
+		wantErr string
+	}{
+		{"no container", "", ""},
+		{"this checkout", cwd, ""},
+		{"other checkout", "/src/other/demo", "running for /src/other/demo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A podman that knows one stopped service container and logs its calls
+			bin := t.TempDir()
+			logPath := filepath.Join(bin, "calls")
+			script := "#!/bin/sh\necho \"$*\" >> " + logPath + "\n"
+			if tt.owner != "" {
+				script += "case \"$*\" in *viber00t.path*) echo " + tt.owner + "; exit 0;; esac\n"
+			}
+			script += "case \"$*\" in \"container inspect\"*) exit 1;; esac\n"
+			ioutil.WriteFile(filepath.Join(bin, "podman"), []byte(script), 0755)
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+			config := decodeConfig(t, "[project]\nname = \"demo\"\n[[services]]\nname = \"db\"\nimage = \"postgres\"\n")
+			_, _, err := startServices(config, NetworkPolicy{Mode: "full"}, &Session{}, cwd)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			data, _ := ioutil.ReadFile(logPath)
+			calls := string(data)
+			if replaced := strings.Contains(calls, "rm -f viber00t-demo-db"); replaced != (tt.wantErr == "") {
+				t.Errorf("container removed = %v, calls:\n%s", replaced, calls)
+			}
+			if started := strings.Contains(calls, "run -d"); started != (tt.wantErr == "") {
+				t.Errorf("service started = %v, calls:\n%s", started, calls)
+			}
+			if tt.wantErr == "" && !strings.Contains(calls, "viber00t.path="+cwd) {
+				t.Errorf("service not labeled with its checkout, calls:\n%s", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Session holds the metadata of a single container run. Everything a session
+// produces (manifests, change reports) lives next to it in its state directory.
+type Session struct {
+	ID           string    `json:"id"`
+	Kind         string    `json:"kind"` // "agent", "shell" or "task"
+	Project      string    `json:"project"`
+	Path         string    `json:"path"`
+	Container    string    `json:"container,omitempty"`
+	Image        string    `json:"image,omitempty"`
+	Command      []string  `json:"command,omitempty"`
+	Ports        []string  `json:"ports,omitempty"`
+	ResumedFrom  string    `json:"resumed_from,omitempty"`
+	Conversation string    `json:"conversation,omitempty"` // the agent's own session ID
+	Snapshot     string    `json:"snapshot,omitempty"`
+	ChangeReport string    `json:"change_report,omitempty"`
+	Recording    string    `json:"recording,omitempty"`
+	Audit        string    `json:"audit,omitempty"`
+	StopReason   string    `json:"stop_reason,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	EndedAt      time.Time `json:"ended_at,omitempty"`
+	Duration     float64   `json:"duration_seconds,omitempty"`
+	ExitCode     int       `json:"exit_code"`
+
+	cleanups []func()
+}
+
+func getSessionsDir() string {
+	return filepath.Join(getXDGStateHome(), "viber00t", "sessions")
+}
+
+func startSession(config *Config, globalConfig *GlobalConfig, kind, cwd string) (*Session, error) {
+	pruneSessions(globalConfig)
+
+	now := time.Now()
+	session := &Session{
+		ID:        fmt.Sprintf("%s-%s", config.Project.Name, now.Format("20060102-150405")),
+		Kind:      kind,
+		Project:   config.Project.Name,
+		Path:      cwd,
+		StartedAt: now,
+	}
+
+	// Two sessions started in the same second get a numeric suffix
+	base := session.ID
+	for n := 2; ; n++ {
+		if _, err := os.Stat(session.Dir()); os.IsNotExist(err) {
+			break
+		}
+		session.ID = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	if err := os.MkdirAll(session.Dir(), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return session, session.save()
+}
+
+func (s *Session) Dir() string {
+	return filepath.Join(getSessionsDir(), s.ID)
+}
+
+func (s *Session) save() error {
+	record := *s
+	record.Command = redactAll(s.Command)
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.Dir(), "session.json"), data, 0600)
+}
+
+// onFinish registers a cleanup to run when the session ends.
+func (s *Session) onFinish(cleanup func()) {
+	s.cleanups = append(s.cleanups, cleanup)
+}
+
+func (s *Session) runCleanups() {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+	s.cleanups = nil
+}
+
+func (s *Session) finish(exitCode int) {
+	s.runCleanups()
+	s.EndedAt = time.Now()
+	s.Duration = s.EndedAt.Sub(s.StartedAt).Seconds()
+	s.ExitCode = exitCode
+	if err := s.save(); err != nil {
+		fmt.Printf("\033[33m⚠\033[0m  Failed to save session: %v\n", err)
+	}
+}
+
+func loadSession(id string) (*Session, error) {
+	data, err := ioutil.ReadFile(filepath.Join(getSessionsDir(), id, "session.json"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown session %s", id)
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("corrupt session %s: %w", id, err)
+	}
+	return &session, nil
+}
+
+// latestSession returns the most recent session started from path.
+func latestSession(path string) (*Session, error) {
+	sessions, err := listSessions()
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.Path == path {
+			return session, nil
+		}
+	}
+	return nil, errors.New("no sessions for this project yet")
+}
+
+// runAttached runs cmd on the terminal, recording it when enabled and
+// enforcing the session's time limits.
+func runAttached(cmd *exec.Cmd, config *Config, globalConfig *GlobalConfig, session *Session) error {
+	dog, err := startWatchdog(session, resolveResources(config, globalConfig))
+	if err != nil {
+		return err
+	}
+	touch := func() {}
+	if dog != nil {
+		defer func() { session.StopReason = dog.stop() }()
+		touch = dog.touch
+	}
+
+	if recordingEnabled(config, globalConfig) {
+		session.Recording = filepath.Join(session.Dir(), "session.cast")
+		return runProxied(cmd, session.Recording, fmt.Sprintf("viber00t %s (%s)", config.Project.Name, session.ID), touch)
+	}
+	if dog != nil && dog.idleTimeout > 0 {
+		// Idleness is only visible when the terminal goes through us
+		return runProxied(cmd, "", "", touch)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// exitCode extracts the process exit status from a cmd.Run error.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
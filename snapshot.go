@@ -0,0 +1,581 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot records the state of a project directory before an agent session.
+// Git projects are stored as a commit on a hidden ref, everything else as a tarball.
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Project   string    `json:"project"`
+	Path      string    `json:"path"`
+	Kind      string    `json:"kind"` // "git" or "tar"
+	Ref       string    `json:"ref,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	Archive   string    `json:"archive,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const defaultSnapshotKeep = 20
+
+func snapshotsEnabled(config *Config) bool {
+	return config.Snapshots.Enabled == nil || *config.Snapshots.Enabled
+}
+
+func getSnapshotDir(config *Config) string {
+	return filepath.Join(getXDGStateHome(), "viber00t", "snapshots", config.Project.Name)
+}
+
+func loadSnapshots(config *Config) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	data, err := ioutil.ReadFile(filepath.Join(getSnapshotDir(config), "index.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return nil, fmt.Errorf("corrupt snapshot index: %w", err)
+	}
+	return snapshots, nil
+}
+
+func saveSnapshots(config *Config, snapshots []Snapshot) error {
+	dir := getSnapshotDir(config)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	data, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "index.json"), data, 0644)
+}
+
+// projectSnapshots returns the snapshots taken for the given path, oldest first.
+func projectSnapshots(config *Config, path string) ([]Snapshot, error) {
+	all, err := loadSnapshots(config)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []Snapshot
+	for _, snap := range all {
+		if snap.Path == path {
+			snapshots = append(snapshots, snap)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// isGitToplevel reports whether dir is the root of a git work tree.
+func isGitToplevel(dir string) bool {
+	out, err := git(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return false
+	}
+	top, err := filepath.EvalSymlinks(out)
+	if err != nil {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	return top == resolved
+}
+
+func takeSnapshot(config *Config, dir, reason string) (*Snapshot, error) {
+	return recordSnapshot(config, dir, reason, true)
+}
+
+// recordSnapshot snapshots dir, dropping the oldest snapshots beyond the
+// limit when prune is set.
+func recordSnapshot(config *Config, dir, reason string, prune bool) (*Snapshot, error) {
+	snapshots, err := loadSnapshots(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// IDs are timestamps, suffixed when several snapshots land in the same second
+	now := time.Now()
+	id := now.Format("20060102-150405")
+	for n := 2; snapshotIndex(snapshots, id) >= 0; n++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), n)
+	}
+
+	snap := Snapshot{
+		ID:        id,
+		Project:   config.Project.Name,
+		Path:      dir,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+
+	if isGitToplevel(dir) {
+		snap.Kind = "git"
+		snap.Ref = "refs/viber00t/snapshots/" + id
+		snap.Commit, err = gitSnapshot(dir, snap.Ref, fmt.Sprintf("viber00t snapshot %s (%s)", id, reason))
+	} else {
+		snap.Kind = "tar"
+		snap.Archive = filepath.Join(getSnapshotDir(config), id+".tar.gz")
+		err = tarSnapshot(dir, snap.Archive, config.Snapshots.Exclude)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots = append(snapshots, snap)
+	if prune {
+		snapshots = pruneSnapshots(config, snapshots, dir)
+	}
+	if err := saveSnapshots(config, snapshots); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+func snapshotIndex(snapshots []Snapshot, id string) int {
+	for i, snap := range snapshots {
+		if snap.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// pruneSnapshots drops the oldest snapshots of dir beyond the configured limit.
+func pruneSnapshots(config *Config, snapshots []Snapshot, dir string) []Snapshot {
+	keep := config.Snapshots.Keep
+	if keep <= 0 {
+		keep = defaultSnapshotKeep
+	}
+
+	count := 0
+	for _, snap := range snapshots {
+		if snap.Path == dir {
+			count++
+		}
+	}
+
+	var kept []Snapshot
+	for _, snap := range snapshots {
+		if snap.Path == dir && count > keep {
+			removeSnapshotData(snap)
+			count--
+			continue
+		}
+		kept = append(kept, snap)
+	}
+	return kept
+}
+
+func removeSnapshotData(snap Snapshot) {
+	switch snap.Kind {
+	case "git":
+		git(snap.Path, nil, "update-ref", "-d", snap.Ref)
+	case "tar":
+		os.Remove(snap.Archive)
+	}
+}
+
+// gitSafeConfig keeps git from running the project's fsmonitor or hooks on the host.
+var gitSafeConfig = []string{"-c", "core.fsmonitor=false", "-c", "core.hooksPath=/dev/null"}
+
+// git runs a git command in dir with extra environment and returns trimmed stdout.
+func git(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", append(append([]string{"-C", dir}, gitSafeConfig...), args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitTree writes the tracked and untracked (non-ignored) files of dir into a
+// tree object using a throwaway index, leaving the real index untouched.
+func gitTree(dir string) (string, error) {
+	tmp, err := ioutil.TempFile("", "viber00t-index-")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	// Seed from the real index so unchanged files don't need rehashing
+	if indexPath, err := git(dir, nil, "rev-parse", "--path-format=absolute", "--git-path", "index"); err == nil {
+		if data, err := ioutil.ReadFile(indexPath); err == nil {
+			ioutil.WriteFile(tmp.Name(), data, 0600)
+		} else {
+			os.Remove(tmp.Name())
+		}
+	}
+
+	env := []string{"GIT_INDEX_FILE=" + tmp.Name()}
+	if _, err := git(dir, env, "add", "-A", "--", "."); err != nil {
+		return "", err
+	}
+	return git(dir, env, "write-tree")
+}
+
+func gitSnapshot(dir, ref, message string) (string, error) {
+	tree, err := gitTree(dir)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"commit-tree", tree, "-m", message}
+	if head, err := git(dir, nil, "rev-parse", "--verify", "-q", "HEAD"); err == nil && head != "" {
+		args = append(args, "-p", head)
+	}
+	env := []string{
+		"GIT_AUTHOR_NAME=viber00t", "GIT_AUTHOR_EMAIL=viber00t@localhost",
+		"GIT_COMMITTER_NAME=viber00t", "GIT_COMMITTER_EMAIL=viber00t@localhost",
+	}
+	commit, err := git(dir, env, args...)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := git(dir, nil, "update-ref", ref, commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+func gitRestore(snap Snapshot, current Snapshot) error {
+	tmp, err := ioutil.TempFile("", "viber00t-index-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name())
+
+	// Check the snapshot tree out through a throwaway index
+	env := []string{"GIT_INDEX_FILE=" + tmp.Name()}
+	if _, err := git(snap.Path, env, "read-tree", snap.Commit); err != nil {
+		return err
+	}
+	if _, err := git(snap.Path, env, "checkout-index", "-a", "-f"); err != nil {
+		return err
+	}
+
+	// Remove files that did not exist when the snapshot was taken
+	out, err := git(snap.Path, nil, "diff", "--name-only", "--no-renames", "-z", "--diff-filter=D", current.Commit, snap.Commit)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(out, "\x00") {
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(snap.Path, name)
+		os.Remove(path)
+		removeEmptyParents(snap.Path, filepath.Dir(path))
+	}
+	return nil
+}
+
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func snapshotExcluded(name string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if ok, _ := filepath.Match(pattern, filepath.Base(name)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func tarSnapshot(dir, archive string, exclude []string) error {
+	if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if rel == "." {
+			return nil
+		}
+		if snapshotExcluded(rel, exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			// Sockets, fifos and devices are not worth keeping
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			src, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, src)
+			src.Close()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		os.Remove(archive)
+		return fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func tarRestore(snap Snapshot, exclude []string) error {
+	f, err := os.Open(snap.Archive)
+	if err != nil {
+		return fmt.Errorf("snapshot archive missing: %w", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	// Extract everything, remembering which paths belong to the snapshot
+	keep := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rel := filepath.FromSlash(hdr.Name)
+		if strings.HasPrefix(rel, "..") || filepath.IsAbs(rel) {
+			continue
+		}
+		keep[rel] = true
+		path := filepath.Join(snap.Path, rel)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.FileMode(hdr.Mode)&os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.RemoveAll(path)
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+				os.RemoveAll(path)
+			}
+			dst, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode)&os.ModePerm)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dst, tr)
+			dst.Close()
+			if err != nil {
+				return err
+			}
+			os.Chmod(path, os.FileMode(hdr.Mode)&os.ModePerm)
+		}
+	}
+
+	// Remove whatever appeared after the snapshot, deepest paths first
+	var extra []string
+	filepath.Walk(snap.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(snap.Path, path)
+		if rel == "." {
+			return nil
+		}
+		if snapshotExcluded(rel, exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !keep[rel] {
+			extra = append(extra, path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	for i := len(extra) - 1; i >= 0; i-- {
+		os.RemoveAll(extra[i])
+	}
+	return nil
+}
+
+func listSnapshots() {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+		os.Exit(1)
+	}
+
+	cwd, _ := os.Getwd()
+	snapshots, err := projectSnapshots(config, cwd)
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to read snapshots:", err)
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("\033[90mNo snapshots for this project yet\033[0m")
+		return
+	}
+
+	fmt.Printf("\033[35m◉\033[0m Snapshots for \033[36m%s\033[0m\n", config.Project.Name)
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snap := snapshots[i]
+		fmt.Printf("  \033[36m%-20s\033[0m %s  %-3s  \033[90m%s\033[0m\n",
+			snap.ID, snap.CreatedAt.Format("2006-01-02 15:04:05"), snap.Kind, snap.Reason)
+	}
+}
+
+func rollback(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+		os.Exit(1)
+	}
+
+	id := ""
+	assumeYes := false
+	for _, arg := range args {
+		if arg == "-y" || arg == "--yes" {
+			assumeYes = true
+		} else {
+			id = arg
+		}
+	}
+
+	cwd, _ := os.Getwd()
+	snapshots, err := projectSnapshots(config, cwd)
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to read snapshots:", err)
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("\033[31m✗\033[0m No snapshots for this project")
+		os.Exit(1)
+	}
+
+	// Default to the most recent snapshot
+	snap := snapshots[len(snapshots)-1]
+	if id != "" {
+		i := snapshotIndex(snapshots, id)
+		if i < 0 {
+			fmt.Printf("\033[31m✗\033[0m Unknown snapshot: %s\n", id)
+			os.Exit(1)
+		}
+		snap = snapshots[i]
+	}
+
+	fmt.Printf("\033[33m⚠\033[0m  This will restore %s to snapshot \033[36m%s\033[0m (%s)\n",
+		cwd, snap.ID, snap.CreatedAt.Format("2006-01-02 15:04:05"))
+	if !assumeYes && !confirm("Continue?") {
+		fmt.Println("\033[90mAborted\033[0m")
+		return
+	}
+
+	// Check the snapshot can be restored before touching anything
+	kind := "tar"
+	if isGitToplevel(cwd) {
+		kind = "git"
+	}
+	if snap.Kind != kind {
+		log.Fatalf("\033[31m✗\033[0m Snapshot %s is a %s snapshot but the project is now snapshotted as %s", snap.ID, snap.Kind, kind)
+	}
+	if snap.Kind == "tar" {
+		if _, err := os.Stat(snap.Archive); err != nil {
+			log.Fatal("\033[31m✗\033[0m Snapshot archive missing:", err)
+		}
+	}
+
+	// Keep the current state around in case the rollback was a mistake. Pruning
+	// waits until the restore is done, it could drop the snapshot being restored.
+	current, err := recordSnapshot(config, cwd, "pre-rollback", false)
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to snapshot current state:", err)
+	}
+	fmt.Printf("\033[35m◉\033[0m Current state saved as snapshot %s\n", current.ID)
+
+	if snap.Kind == "git" {
+		err = gitRestore(snap, *current)
+	} else {
+		err = tarRestore(snap, config.Snapshots.Exclude)
+	}
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Rollback failed:", err)
+	}
+	if snapshots, err := loadSnapshots(config); err == nil {
+		saveSnapshots(config, pruneSnapshots(config, snapshots, cwd))
+	}
+	fmt.Printf("\033[32m✓\033[0m Restored snapshot %s\n", snap.ID)
+}
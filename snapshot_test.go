@@ -0,0 +1,244 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestPruneSnapshots(t *testing.T) {
+	tests := []struct {
+		name  string
+		keep  int
+		paths []string // one snapshot per entry, oldest first
+		want  []string // IDs left
+	}{
+		{"under the limit", 3, []string{"a", "a"}, []string{"0", "1"}},
+		{"at the limit", 2, []string{"a", "a"}, []string{"0", "1"}},
+		{"oldest go first", 2, []string{"a", "a", "a", "a"}, []string{"2", "3"}},
+		{"other projects untouched", 1, []string{"b", "a", "b", "a"}, []string{"0", "2", "3"}},
+		{"default limit", 0, make([]string, defaultSnapshotKeep+1), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := &Config{}
+			config.Snapshots.Keep = tt.keep
+
+			var snapshots []Snapshot
+			for i, path := range tt.paths {
+				id := strconv.Itoa(i)
+				archive := filepath.Join(dir, id+".tar.gz")
+				ioutil.WriteFile(archive, nil, 0644)
+				snapshots = append(snapshots, Snapshot{ID: id, Path: path, Kind: "tar", Archive: archive})
+			}
+			kept := pruneSnapshots(config, snapshots, tt.paths[len(tt.paths)-1])
+
+			if tt.want == nil {
+				if len(kept) != defaultSnapshotKeep {
+					t.Fatalf("kept %d snapshots, want %d", len(kept), defaultSnapshotKeep)
+				}
+				return
+			}
+			var ids []string
+			for _, snap := range kept {
+				ids = append(ids, snap.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Fatalf("kept %v, want %v", ids, tt.want)
+			}
+			// Dropped snapshots lose their archive, kept ones don't
+			for _, snap := range snapshots {
+				_, err := os.Stat(snap.Archive)
+				if exists, wanted := err == nil, snapshotIndex(kept, snap.ID) >= 0; exists != wanted {
+					t.Errorf("archive of %s exists = %v, want %v", snap.ID, exists, wanted)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshotExcluded(t *testing.T) {
+	tests := []struct {
+		name    string
+		exclude []string
+		want    bool
+	}{
+		{"node_modules", []string{"node_modules"}, true},
+		{"web/node_modules", []string{"node_modules"}, true},
+		{"build/out.o", []string{"*.o"}, true},
+		{"build/out.o", []string{"build/*"}, true},
+		{"src/main.go", []string{"*.o", "node_modules"}, false},
+		{"src/main.go", nil, false},
+	}
+	for _, tt := range tests {
+		if got := snapshotExcluded(tt.name, tt.exclude); got != tt.want {
+			t.Errorf("snapshotExcluded(%q, %v) = %v, want %v", tt.name, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+// writeTree creates files (path → content) under dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the regular files under dir, skipping .git.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(dir, path)
+			data, _ := ioutil.ReadFile(path)
+			files[filepath.ToSlash(rel)] = string(data)
+		}
+		return nil
+	})
+	return files
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	before := map[string]string{
+		"main.go":        "package main\n",
+		"docs/README.md": "# docs\n",
+		"cache/big.bin":  "cached\n",
+	}
+	tests := []struct {
+		name    string
+		git     bool
+		exclude []string
+		change  func(dir string)
+		want    map[string]string
+	}{
+		{
+			name: "tar restores edits and removes new files",
+			change: func(dir string) {
+				ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("broken"), 0644)
+				os.MkdirAll(filepath.Join(dir, "new"), 0755)
+				ioutil.WriteFile(filepath.Join(dir, "new", "file"), []byte("x"), 0644)
+			},
+			want: before,
+		},
+		{
+			name: "tar restores deleted files",
+			change: func(dir string) {
+				os.RemoveAll(filepath.Join(dir, "docs"))
+			},
+			want: before,
+		},
+		{
+			name:    "tar leaves excluded paths alone",
+			exclude: []string{"cache"},
+			change: func(dir string) {
+				ioutil.WriteFile(filepath.Join(dir, "cache", "big.bin"), []byte("rebuilt\n"), 0644)
+			},
+			want: map[string]string{"main.go": "package main\n", "docs/README.md": "# docs\n", "cache/big.bin": "rebuilt\n"},
+		},
+		{
+			name: "git restores edits, deletions and new files",
+			git:  true,
+			change: func(dir string) {
+				ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("broken"), 0644)
+				os.Remove(filepath.Join(dir, "docs", "README.md"))
+				ioutil.WriteFile(filepath.Join(dir, "docs", "new.md"), []byte("x"), 0644)
+			},
+			want: before,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.git {
+				if _, err := exec.LookPath("git"); err != nil {
+					t.Skip("git not installed")
+				}
+			}
+			t.Setenv("XDG_STATE_HOME", t.TempDir())
+			dir := t.TempDir()
+			writeTree(t, dir, before)
+			if tt.git {
+				if _, err := git(dir, nil, "init", "-q"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := &Config{}
+			config.Project.Name = "test"
+			config.Snapshots.Exclude = tt.exclude
+			snap, err := takeSnapshot(config, dir, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[bool]string{true: "git", false: "tar"}[tt.git]; snap.Kind != want {
+				t.Fatalf("snapshot kind %s, want %s", snap.Kind, want)
+			}
+
+			tt.change(dir)
+			if snap.Kind == "git" {
+				var current *Snapshot
+				if current, err = recordSnapshot(config, dir, "pre-rollback", false); err != nil {
+					t.Fatal(err)
+				}
+				err = gitRestore(*snap, *current)
+			} else {
+				err = tarRestore(*snap, tt.exclude)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readTree(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("restored %v, want %v", got, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "new")); err == nil {
+				t.Errorf("directory created after the snapshot survived the restore")
+			}
+		})
+	}
+}
+
+func TestGitSnapshotIgnoresProjectHooks(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "hook-ran")
+	script := filepath.Join(t.TempDir(), "hook")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\ntouch "+marker+"\n"), 0755)
+	writeTree(t, dir, map[string]string{"main.go": "package main\n"})
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "core.fsmonitor", script},
+		{"config", "core.hooksPath", filepath.Dir(script)},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	if _, err := gitSnapshot(dir, "refs/viber00t/snapshots/test", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("the project's fsmonitor ran")
+	}
+}
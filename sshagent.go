@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSHPolicy controls how git-over-ssh works inside the container.
+type SSHPolicy struct {
+	Agent       *bool    // forward the host SSH agent (default true)
+	AllowedKeys []string `toml:"allowed_keys"` // key fingerprints the container may use, empty allows all
+	Confirm     *bool    // ask on the host before every signature
+	Log         *bool    // log every signing request to the session
+}
+
+const containerSSHAgentSock = "/run/viber00t/ssh-agent.sock"
+
+// SSH agent protocol message numbers (draft-miller-ssh-agent)
+const (
+	sshAgentFailure           = 5
+	sshAgentRequestIdentities = 11
+	sshAgentIdentitiesAnswer  = 12
+	sshAgentSignRequest       = 13
+)
+
+// resolveSSHPolicy merges the project [ssh] section over the global one.
+func resolveSSHPolicy(config *Config, globalConfig *GlobalConfig) SSHPolicy {
+	policy := globalConfig.SSH
+	if config.SSH.Agent != nil {
+		policy.Agent = config.SSH.Agent
+	}
+	if config.SSH.AllowedKeys != nil {
+		policy.AllowedKeys = config.SSH.AllowedKeys
+	}
+	if config.SSH.Confirm != nil {
+		policy.Confirm = config.SSH.Confirm
+	}
+	if config.SSH.Log != nil {
+		policy.Log = config.SSH.Log
+	}
+	return policy
+}
+
+func (p SSHPolicy) forwardAgent() bool {
+	return (p.Agent == nil || *p.Agent) && os.Getenv("SSH_AUTH_SOCK") != ""
+}
+
+// filtered reports whether signing requests need to go through the proxy.
+func (p SSHPolicy) filtered() bool {
+	return len(p.AllowedKeys) > 0 || (p.Confirm != nil && *p.Confirm) || (p.Log != nil && *p.Log)
+}
+
+// sshMounts describes the agent socket and the ssh client files exposed to
+// the container. sessionDir is empty when only describing the mounts.
+func sshMounts(policy SSHPolicy, sshDirMounted bool, sessionDir string) []Mount {
+	if sessionDir == "" {
+		sessionDir = "<session>"
+	}
+
+	var mounts []Mount
+	if policy.forwardAgent() {
+		mount := Mount{
+			Name:   "ssh_agent",
+			Source: os.Getenv("SSH_AUTH_SOCK"),
+			Target: containerSSHAgentSock,
+			Mode:   "rw",
+			Reason: "SSH agent forwarding",
+		}
+		if policy.filtered() {
+			mount.Source = filepath.Join(sessionDir, "ssh-agent.sock")
+			mount.Reason = "SSH agent forwarding through the filtering proxy"
+		}
+		mounts = append(mounts, mount)
+	}
+
+	// Without the full ~/.ssh mount, only known_hosts and config are copied in
+	if !sshDirMounted {
+		for _, name := range []string{"known_hosts", "config"} {
+			if _, err := os.Stat(expandPath("~/.ssh/" + name)); err == nil {
+				mounts = append(mounts, Mount{
+					Name:   "ssh_" + name,
+					Source: filepath.Join(sessionDir, "ssh", name),
+					Target: "/root/.ssh/" + name,
+					Mode:   "ro",
+					Reason: "copy of ~/.ssh/" + name,
+				})
+			}
+		}
+	}
+	return mounts
+}
+
+// setupSSH copies the ssh client files into the session and starts the
+// filtering agent proxy when the policy asks for one.
+func setupSSH(policy SSHPolicy, mounts []Mount, session *Session) error {
+	for _, mount := range mounts {
+		if !strings.HasPrefix(mount.Name, "ssh_") || mount.Name == "ssh_agent" {
+			continue
+		}
+		name := strings.TrimPrefix(mount.Name, "ssh_")
+		data, err := ioutil.ReadFile(expandPath("~/.ssh/" + name))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(mount.Source), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(mount.Source, data, 0600); err != nil {
+			return err
+		}
+	}
+
+	if policy.forwardAgent() && policy.filtered() {
+		if policy.Confirm != nil && *policy.Confirm && !askpassAvailable() {
+			return fmt.Errorf("[ssh] confirm = true needs SSH_ASKPASS and a display on the host")
+		}
+		proxy := &sshAgentProxy{
+			upstream: os.Getenv("SSH_AUTH_SOCK"),
+			policy:   policy,
+			logPath:  filepath.Join(session.Dir(), "ssh-agent.log"),
+			comments: map[string]string{},
+		}
+		return proxy.listen(filepath.Join(session.Dir(), "ssh-agent.sock"), session)
+	}
+	return nil
+}
+
+// sshAgentProxy sits between the container and the host agent. It only
+// lists and signs with allowed keys, and refuses anything that would modify
+// the host agent.
+type sshAgentProxy struct {
+	upstream string
+	policy   SSHPolicy
+	logPath  string
+
+	mu       sync.Mutex
+	comments map[string]string
+}
+
+func (p *sshAgentProxy) listen(path string, session *Session) error {
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to start ssh agent proxy: %w", err)
+	}
+	os.Chmod(path, 0600)
+	session.onFinish(func() {
+		listener.Close()
+		os.Remove(path)
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return nil
+}
+
+func sshFingerprint(blob []byte) string {
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func (p *sshAgentProxy) allowed(fingerprint string) bool {
+	if len(p.policy.AllowedKeys) == 0 {
+		return true
+	}
+	for _, key := range p.policy.AllowedKeys {
+		if key == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func readAgentMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 || length > 256*1024 {
+		return nil, errors.New("invalid agent message length")
+	}
+	msg := make([]byte, length)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+func writeAgentMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(buf, uint32(len(msg)))
+	copy(buf[4:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readSSHString reads a length-prefixed string and returns it with the rest.
+func readSSHString(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, errors.New("short agent message")
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return nil, nil, errors.New("short agent message")
+	}
+	return b[4 : 4+n], b[4+n:], nil
+}
+
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func (p *sshAgentProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	upstream, err := net.Dial("unix", p.upstream)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	for {
+		msg, err := readAgentMessage(conn)
+		if err != nil {
+			return
+		}
+
+		var reply []byte
+		switch msg[0] {
+		case sshAgentRequestIdentities:
+			reply, err = p.identities(upstream, msg)
+		case sshAgentSignRequest:
+			reply, err = p.sign(upstream, msg)
+		default:
+			// Adding, removing, locking and extensions stay on the host
+			reply = []byte{sshAgentFailure}
+		}
+		if err != nil {
+			reply = []byte{sshAgentFailure}
+		}
+		if err := writeAgentMessage(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func roundTrip(upstream net.Conn, msg []byte) ([]byte, error) {
+	if err := writeAgentMessage(upstream, msg); err != nil {
+		return nil, err
+	}
+	return readAgentMessage(upstream)
+}
+
+// identities forwards the key listing, dropping keys that are not allowed.
+func (p *sshAgentProxy) identities(upstream net.Conn, msg []byte) ([]byte, error) {
+	reply, err := roundTrip(upstream, msg)
+	if err != nil || reply[0] != sshAgentIdentitiesAnswer || len(reply) < 5 {
+		return reply, err
+	}
+
+	count := binary.BigEndian.Uint32(reply[1:])
+	rest := reply[5:]
+	var keys []byte
+	kept := uint32(0)
+	for i := uint32(0); i < count; i++ {
+		var blob, comment []byte
+		if blob, rest, err = readSSHString(rest); err != nil {
+			return nil, err
+		}
+		if comment, rest, err = readSSHString(rest); err != nil {
+			return nil, err
+		}
+		fingerprint := sshFingerprint(blob)
+		if !p.allowed(fingerprint) {
+			continue
+		}
+		p.mu.Lock()
+		p.comments[fingerprint] = string(comment)
+		p.mu.Unlock()
+		keys = appendSSHString(keys, blob)
+		keys = appendSSHString(keys, comment)
+		kept++
+	}
+
+	filtered := []byte{sshAgentIdentitiesAnswer}
+	filtered = binary.BigEndian.AppendUint32(filtered, kept)
+	return append(filtered, keys...), nil
+}
+
+func (p *sshAgentProxy) sign(upstream net.Conn, msg []byte) ([]byte, error) {
+	blob, _, err := readSSHString(msg[1:])
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sshFingerprint(blob)
+	p.mu.Lock()
+	comment := p.comments[fingerprint]
+	p.mu.Unlock()
+
+	verdict := "allowed"
+	switch {
+	case !p.allowed(fingerprint):
+		verdict = "denied (key not allowed)"
+	case p.policy.Confirm != nil && *p.policy.Confirm && !askpassConfirm(fmt.Sprintf("viber00t: allow the container to sign with %s (%s)?", comment, fingerprint)):
+		verdict = "denied (not confirmed)"
+	}
+	p.logRequest(fingerprint, comment, verdict)
+
+	if verdict != "allowed" {
+		return []byte{sshAgentFailure}, nil
+	}
+	return roundTrip(upstream, msg)
+}
+
+func (p *sshAgentProxy) logRequest(fingerprint, comment, verdict string) {
+	f, err := os.OpenFile(p.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s sign %s %s: %s\n", time.Now().Format(time.RFC3339), fingerprint, comment, verdict)
+}
+
+// askpassAvailable reports whether SSH_ASKPASS can show a prompt on the host.
+func askpassAvailable() bool {
+	return os.Getenv("SSH_ASKPASS") != "" && os.Getenv("DISPLAY")+os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// askpassConfirm asks a yes/no question through SSH_ASKPASS, one at a time.
+// The terminal is never used: the attached session is reading from it.
+var confirmMu sync.Mutex
+
+func askpassConfirm(prompt string) bool {
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+
+	if !askpassAvailable() {
+		return false
+	}
+	cmd := exec.Command(os.Getenv("SSH_ASKPASS"), prompt)
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+	return cmd.Run() == nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeAgent answers identity requests with keys and signs anything it is
+// asked to, counting the signatures.
+func fakeAgent(conn net.Conn, keys [][]byte, signed *int) {
+	defer conn.Close()
+	for {
+		msg, err := readAgentMessage(conn)
+		if err != nil {
+			return
+		}
+		var reply []byte
+		switch msg[0] {
+		case sshAgentRequestIdentities:
+			reply = binary.BigEndian.AppendUint32([]byte{sshAgentIdentitiesAnswer}, uint32(len(keys)))
+			for _, key := range keys {
+				reply = appendSSHString(reply, key)
+				reply = appendSSHString(reply, []byte("comment "+string(key)))
+			}
+		case sshAgentSignRequest:
+			*signed++
+			reply = appendSSHString([]byte{14}, []byte("signature"))
+		default:
+			reply = []byte{6} // SSH_AGENT_SUCCESS, the proxy must not get here
+		}
+		if err := writeAgentMessage(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func TestSSHAgentProxyFiltering(t *testing.T) {
+	keyA, keyB := []byte("key-a"), []byte("key-b")
+	tests := []struct {
+		name    string
+		allowed []string
+		askpass string // exit status of SSH_ASKPASS, empty for no confirmation
+		listed  [][]byte
+		signs   map[string]bool // key → signature granted
+	}{
+		{
+			name:   "no allowlist",
+			listed: [][]byte{keyA, keyB},
+			signs:  map[string]bool{"key-a": true, "key-b": true},
+		},
+		{
+			name:    "one key allowed",
+			allowed: []string{sshFingerprint(keyB)},
+			listed:  [][]byte{keyB},
+			signs:   map[string]bool{"key-a": false, "key-b": true},
+		},
+		{
+			name:    "unknown fingerprint",
+			allowed: []string{"SHA256:nothing"},
+			signs:   map[string]bool{"key-a": false, "key-b": false},
+		},
+		{
+			name:    "confirmed",
+			askpass: "0",
+			listed:  [][]byte{keyA, keyB},
+			signs:   map[string]bool{"key-a": true, "key-b": true},
+		},
+		{
+			name:    "not confirmed",
+			askpass: "1",
+			listed:  [][]byte{keyA, keyB},
+			signs:   map[string]bool{"key-a": false, "key-b": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			upstream := filepath.Join(dir, "agent.sock")
+			listener, err := net.Listen("unix", upstream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			signed := 0
+			agentDone := make(chan struct{})
+			go func() {
+				defer close(agentDone)
+				if conn, err := listener.Accept(); err == nil {
+					fakeAgent(conn, [][]byte{keyA, keyB}, &signed)
+				}
+			}()
+
+			policy := SSHPolicy{AllowedKeys: tt.allowed}
+			if tt.askpass != "" {
+				askpass := filepath.Join(dir, "askpass")
+				ioutil.WriteFile(askpass, []byte("#!/bin/sh\nexit "+tt.askpass+"\n"), 0755)
+				t.Setenv("SSH_ASKPASS", askpass)
+				t.Setenv("DISPLAY", ":0")
+				yes := true
+				policy.Confirm = &yes
+			}
+			proxy := &sshAgentProxy{
+				upstream: upstream,
+				policy:   policy,
+				logPath:  filepath.Join(dir, "ssh.log"),
+				comments: map[string]string{},
+			}
+			client, server := net.Pipe()
+			defer client.Close()
+			go proxy.serve(server)
+
+			request := func(msg []byte) []byte {
+				t.Helper()
+				if err := writeAgentMessage(client, msg); err != nil {
+					t.Fatal(err)
+				}
+				reply, err := readAgentMessage(client)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return reply
+			}
+
+			reply := request([]byte{sshAgentRequestIdentities})
+			if reply[0] != sshAgentIdentitiesAnswer {
+				t.Fatalf("identities reply type %d", reply[0])
+			}
+			var listed [][]byte
+			rest := reply[5:]
+			for i := binary.BigEndian.Uint32(reply[1:]); i > 0; i-- {
+				var blob []byte
+				blob, rest, _ = readSSHString(rest)
+				_, rest, _ = readSSHString(rest)
+				listed = append(listed, blob)
+			}
+			if !reflect.DeepEqual(listed, tt.listed) {
+				t.Errorf("listed %q, want %q", listed, tt.listed)
+			}
+
+			granted := 0
+			for _, key := range [][]byte{keyA, keyB} {
+				msg := appendSSHString([]byte{sshAgentSignRequest}, key)
+				msg = appendSSHString(msg, []byte("data"))
+				msg = binary.BigEndian.AppendUint32(msg, 0)
+				ok := request(msg)[0] != sshAgentFailure
+				if ok != tt.signs[string(key)] {
+					t.Errorf("signing with %s granted = %v, want %v", key, ok, tt.signs[string(key)])
+				}
+				if ok {
+					granted++
+				}
+			}
+
+			// Adding keys (SSH_AGENTC_ADD_IDENTITY) never reaches the host agent
+			if reply := request([]byte{17}); reply[0] != sshAgentFailure {
+				t.Errorf("add identity reply type %d, want failure", reply[0])
+			}
+			// Closing the client ends the proxy's upstream connection too
+			client.Close()
+			<-agentDone
+			if signed != granted {
+				t.Errorf("host agent signed %d times, want %d", signed, granted)
+			}
+		})
+	}
+}
+
+func TestSetupSSHConfirmNeedsAskpass(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv("DISPLAY", "")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	session := &Session{ID: "test-ssh-confirm"}
+	yes := true
+
+	err := setupSSH(SSHPolicy{Confirm: &yes}, nil, session)
+	if err == nil || !strings.Contains(err.Error(), "SSH_ASKPASS") {
+		t.Errorf("err = %v, want a missing SSH_ASKPASS error", err)
+	}
+}
+
+func TestReadSSHString(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		value   string
+		rest    string
+		wantErr bool
+	}{
+		{"exact", appendSSHString(nil, []byte("abc")), "abc", "", false},
+		{"with rest", append(appendSSHString(nil, []byte("abc")), 'x'), "abc", "x", false},
+		{"empty string", appendSSHString(nil, nil), "", "", false},
+		{"short length", []byte{0, 0, 1}, "", "", true},
+		{"length past the end", []byte{0, 0, 0, 5, 'a'}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, rest, err := readSSHString(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if string(value) != tt.value || string(rest) != tt.rest {
+				t.Errorf("got %q, %q, want %q, %q", value, rest, tt.value, tt.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Task is an entry of [tasks], run inside the project environment with
+// 'viber00t run <task>'. Dependencies run first, side by side where they can.
+type Task struct {
+	Command     string
+	Description string
+	Env         map[string]string
+	Workdir     string   // relative to the project
+	Deps        []string // tasks that must succeed first
+	Services    bool     // start the [[services]] for it
+	Parallel    *bool    // false runs it alone, default true
+}
+
+// plannedTask is a task handed to the container, in dependency order.
+type plannedTask struct {
+	Name     string            `json:"name"`
+	Command  string            `json:"command"`
+	Env      map[string]string `json:"env,omitempty"`
+	Workdir  string            `json:"workdir,omitempty"`
+	Deps     []string          `json:"deps,omitempty"`
+	Parallel bool              `json:"parallel"`
+	Args     []string          `json:"args,omitempty"`
+}
+
+const containerTasksEnv = "VIBER00T_TASKS"
+
+func checkTasks(tasks map[string]Task) error {
+	for name, task := range tasks {
+		if name == "" || strings.ContainsAny(name, " \t/") {
+			return fmt.Errorf("Viber00t.toml: invalid task name %q", name)
+		}
+		if task.Command == "" {
+			return fmt.Errorf("Viber00t.toml: task %s needs a command", name)
+		}
+		for _, dep := range task.Deps {
+			if _, ok := tasks[dep]; !ok {
+				return fmt.Errorf("Viber00t.toml: task %s depends on unknown task %q", name, dep)
+			}
+		}
+	}
+	return nil
+}
+
+// planTasks orders target and everything it depends on, dependencies first.
+// args go to the target only.
+func planTasks(tasks map[string]Task, target string, args []string) ([]plannedTask, error) {
+	if _, ok := tasks[target]; !ok {
+		return nil, fmt.Errorf("no task %q in Viber00t.toml, see 'viber00t tasks'", target)
+	}
+
+	var plan []plannedTask
+	state := make(map[string]int) // 1 visiting, 2 planned
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("Viber00t.toml: tasks depend on each other: %s", strings.Join(append(path, name), " → "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		task := tasks[name]
+		for _, dep := range task.Deps {
+			if err := visit(dep, append(append([]string(nil), path...), name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		plan = append(plan, plannedTask{
+			Name:     name,
+			Command:  task.Command,
+			Env:      task.Env,
+			Workdir:  task.Workdir,
+			Deps:     task.Deps,
+			Parallel: task.Parallel == nil || *task.Parallel,
+		})
+		return nil
+	}
+	if err := visit(target, nil); err != nil {
+		return nil, err
+	}
+	plan[len(plan)-1].Args = args
+	return plan, nil
+}
+
+// runTask runs a task and its dependencies in a one-off project container,
+// set up like the agent's: same mounts, policies and hooks.
+func runTask(args []string) {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+		os.Exit(1)
+	}
+	if len(args) == 0 {
+		fmt.Println("Usage: viber00t run <task> [args...]")
+		showTasks()
+		os.Exit(1)
+	}
+	if err := checkTasks(config.Tasks); err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	plan, err := planTasks(config.Tasks, args[0], args[1:])
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+
+	cwd, _ := os.Getwd()
+	requireTrust(config, cwd)
+
+	// Services only come up when some task in the plan asks for them
+	needsServices := false
+	for _, task := range plan {
+		needsServices = needsServices || config.Tasks[task.Name].Services
+	}
+	if !needsServices {
+		trimmed := *config
+		trimmed.Services = nil
+		config = &trimmed
+	}
+
+	if !dryRun {
+		if err := buildProjectImage(config); err != nil {
+			log.Fatal("\033[31m✗\033[0m Failed to build image:", err)
+		}
+	}
+	globalConfig, _ := loadGlobalConfig()
+
+	session, err := startSession(config, globalConfig, "task", cwd)
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to start session:", err)
+	}
+	// One container per run, so tasks can run next to the agent and each other
+	containerName := fmt.Sprintf("viber00t-task-%s-%s", filepath.Base(cwd), strings.TrimPrefix(session.ID, config.Project.Name+"-"))
+
+	encoded, _ := json.Marshal(plan)
+	runArgs, err := containerArgs(config, globalConfig, session, cwd, containerName)
+	if err != nil {
+		session.finish(-1)
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	runArgs = append(runArgs, "--rm", "-e", containerTasksEnv+"="+string(encoded))
+
+	imageName := getProjectImageName(config)
+	runArgs = append(runArgs, imageName)
+	runArgs = append(runArgs, initCommand(config, globalConfig, []string{containerToolPath, "_tasks"})...)
+
+	session.Container = containerName
+	session.Image = imageName
+	session.Command = append([]string{"run"}, args...)
+
+	if dryRun {
+		printDryRun(runArgs, session)
+		return
+	}
+
+	fmt.Printf("\033[35m◉\033[0m Running task \033[36m%s\033[0m for \033[36m%s\033[0m...\n", args[0], config.Project.Name)
+	fmt.Println("\033[90m───────────────────────────────────\033[0m")
+
+	cmd := exec.Command("podman", runArgs...)
+	runErr := runAttached(cmd, config, globalConfig, session)
+	runHostHooks("post_run", config.Hooks.PostRun, session,
+		hostHookEnv(config, session, fmt.Sprintf("VIBER00T_EXIT_CODE=%d", exitCode(runErr))))
+	session.finish(exitCode(runErr))
+
+	if code := exitCode(runErr); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// runTasks runs inside the container (viber00t _tasks). A task starts once its
+// dependencies succeeded; after a failure nothing new starts.
+func runTasks() {
+	var plan []plannedTask
+	if err := json.Unmarshal([]byte(os.Getenv(containerTasksEnv)), &plan); err != nil {
+		fmt.Fprintf(os.Stderr, "viber00t: invalid tasks: %v\n", err)
+		os.Exit(2)
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result)
+	var output sync.Mutex
+	started := make(map[string]bool)
+	succeeded := make(map[string]bool)
+	running, exclusive, code := 0, false, 0
+
+	ready := func(task plannedTask) bool {
+		for _, dep := range task.Deps {
+			if !succeeded[dep] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		for _, task := range plan {
+			if code != 0 || exclusive || started[task.Name] || !ready(task) {
+				continue
+			}
+			if !task.Parallel && running > 0 {
+				continue
+			}
+			started[task.Name] = true
+			running++
+			exclusive = !task.Parallel
+			go func(task plannedTask) {
+				results <- result{task.Name, runPlannedTask(task, len(plan) > 1, &output)}
+			}(task)
+		}
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		exclusive = false
+		if r.err != nil {
+			if code == 0 {
+				code = exitCode(r.err)
+				if code <= 0 {
+					code = 1
+				}
+			}
+			continue
+		}
+		succeeded[r.name] = true
+	}
+
+	var skipped []string
+	for _, task := range plan {
+		if !started[task.Name] {
+			skipped = append(skipped, task.Name)
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Printf("\033[90mSkipped: %s\033[0m\n", strings.Join(skipped, ", "))
+	}
+	os.Exit(code)
+}
+
+// runPlannedTask runs one task through the shell. With several tasks in the
+// plan, output lines are prefixed with the task name.
+func runPlannedTask(task plannedTask, prefix bool, output *sync.Mutex) error {
+	report := func(format string, a ...interface{}) {
+		output.Lock()
+		defer output.Unlock()
+		fmt.Printf(format, a...)
+	}
+	report("\033[35m◉\033[0m %s: \033[36m%s\033[0m\n", task.Name, task.Command)
+
+	cmd := exec.Command("sh", append([]string{"-c", task.Command, task.Name}, task.Args...)...)
+	cmd.Dir = task.Workdir
+	cmd.Env = append(os.Environ(), "VIBER00T_TASK="+task.Name)
+	keys := make([]string, 0, len(task.Env))
+	for key := range task.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, key+"="+task.Env[key])
+	}
+
+	var pipes []*prefixedLines
+	if prefix {
+		stdout := &prefixedLines{prefix: task.Name, out: os.Stdout, mu: output}
+		stderr := &prefixedLines{prefix: task.Name, out: os.Stderr, mu: output}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		pipes = append(pipes, stdout, stderr)
+	} else {
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	for _, pipe := range pipes {
+		pipe.flush()
+	}
+	if err != nil {
+		report("\033[31m✗\033[0m %s failed (%v)\n", task.Name, err)
+		return err
+	}
+	report("\033[32m✓\033[0m %s \033[90m%.1fs\033[0m\n", task.Name, time.Since(start).Seconds())
+	return nil
+}
+
+// prefixedLines writes whole lines tagged with the task they came from, so
+// tasks running side by side don't interleave mid-line.
+type prefixedLines struct {
+	prefix  string
+	out     io.Writer
+	mu      *sync.Mutex
+	pending []byte
+}
+
+func (p *prefixedLines) Write(data []byte) (int, error) {
+	p.pending = append(p.pending, data...)
+	for {
+		i := bytes.IndexByte(p.pending, '\n')
+		if i < 0 {
+			break
+		}
+		p.line(p.pending[:i+1])
+		p.pending = p.pending[i+1:]
+	}
+	return len(data), nil
+}
+
+func (p *prefixedLines) flush() {
+	if len(p.pending) > 0 {
+		p.line(append(p.pending, '\n'))
+		p.pending = nil
+	}
+}
+
+func (p *prefixedLines) line(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.out, "\033[90m[%s]\033[0m %s", p.prefix, line)
+}
+
+// showTasks lists the tasks of the project.
+func showTasks() {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+		os.Exit(1)
+	}
+	if len(config.Tasks) == 0 {
+		fmt.Println("\033[90mNo [tasks] in Viber00t.toml\033[0m")
+		return
+	}
+
+	names := make([]string, 0, len(config.Tasks))
+	for name := range config.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		task := config.Tasks[name]
+		description := task.Description
+		if description == "" {
+			description = task.Command
+		}
+		var extras []string
+		if len(task.Deps) > 0 {
+			extras = append(extras, "after "+strings.Join(task.Deps, ", "))
+		}
+		if task.Services {
+			extras = append(extras, "with services")
+		}
+		line := fmt.Sprintf("  \033[36m%-16s\033[0m %s", name, description)
+		if len(extras) > 0 {
+			line += fmt.Sprintf(" \033[90m(%s)\033[0m", strings.Join(extras, "; "))
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPlanTasks(t *testing.T) {
+	no := false
+	tasks := map[string]Task{
+		"build":   {Command: "go build"},
+		"lint":    {Command: "go vet"},
+		"test":    {Command: "go test", Deps: []string{"build"}},
+		"check":   {Command: "true", Deps: []string{"lint", "test"}},
+		"release": {Command: "goreleaser", Deps: []string{"check", "build"}, Parallel: &no},
+		"a":       {Command: "a", Deps: []string{"b"}},
+		"b":       {Command: "b", Deps: []string{"c"}},
+		"c":       {Command: "c", Deps: []string{"a"}},
+		"self":    {Command: "self", Deps: []string{"self"}},
+		"uses-a":  {Command: "x", Deps: []string{"a"}},
+	}
+	tests := []struct {
+		target  string
+		order   []string
+		wantErr string
+	}{
+		{target: "build", order: []string{"build"}},
+		{target: "test", order: []string{"build", "test"}},
+		{target: "check", order: []string{"lint", "build", "test", "check"}},
+		{target: "release", order: []string{"lint", "build", "test", "check", "release"}},
+		{target: "a", wantErr: "a → b → c → a"},
+		{target: "self", wantErr: "self → self"},
+		{target: "uses-a", wantErr: "a → b → c → a"},
+		{target: "deploy", wantErr: `no task "deploy"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			plan, err := planTasks(tasks, tt.target, []string{"-v"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var order []string
+			for _, task := range plan {
+				order = append(order, task.Name)
+			}
+			if !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("order %v, want %v", order, tt.order)
+			}
+			// Arguments go to the target only
+			for i, task := range plan {
+				if last := i == len(plan)-1; last != (task.Args != nil) {
+					t.Errorf("%s got args %v", task.Name, task.Args)
+				}
+			}
+			if last := plan[len(plan)-1]; last.Name == "release" && last.Parallel {
+				t.Errorf("release runs in parallel despite parallel = false")
+			}
+		})
+	}
+}
+
+func TestCheckTasks(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   map[string]Task
+		wantErr string
+	}{
+		{"valid", map[string]Task{"build": {Command: "make"}, "test": {Command: "make test", Deps: []string{"build"}}}, ""},
+		{"no command", map[string]Task{"build": {}}, "needs a command"},
+		{"unknown dep", map[string]Task{"test": {Command: "x", Deps: []string{"build"}}}, "unknown task"},
+		{"space in name", map[string]Task{"my task": {Command: "x"}}, "invalid task name"},
+		{"slash in name", map[string]Task{"a/b": {Command: "x"}}, "invalid task name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTasks(tt.tasks)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrefixedLines(t *testing.T) {
+	var out bytes.Buffer
+	p := &prefixedLines{prefix: "build", out: &out, mu: &sync.Mutex{}}
+	p.Write([]byte("one\ntw"))
+	p.Write([]byte("o\nthree"))
+	p.flush()
+
+	want := "\033[90m[build]\033[0m one\n\033[90m[build]\033[0m two\n\033[90m[build]\033[0m three\n"
+	if out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
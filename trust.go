@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TrustEntry records that the user accepted a project's risky settings.
+type TrustEntry struct {
+	Hash      string    `json:"hash"`
+	TrustedAt time.Time `json:"trusted_at"`
+	Settings  []string  `json:"settings"`
+}
+
+func getTrustPath() string {
+	return filepath.Join(getXDGStateHome(), "viber00t", "trust.json")
+}
+
+func loadTrustStore() (map[string]TrustEntry, error) {
+	store := map[string]TrustEntry{}
+	data, err := ioutil.ReadFile(getTrustPath())
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("corrupt trust store %s: %w", getTrustPath(), err)
+	}
+	return store, nil
+}
+
+func saveTrustStore(store map[string]TrustEntry) error {
+	if err := os.MkdirAll(filepath.Dir(getTrustPath()), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getTrustPath(), data, 0600)
+}
+
+// trustKey identifies a project by its resolved absolute path.
+func trustKey(cwd string) string {
+	if abs, err := filepath.Abs(cwd); err == nil {
+		cwd = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = resolved
+	}
+	return cwd
+}
+
+func describeBool(name string, value *bool) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s = %v", name, *value)
+}
+
+func joinSettings(settings ...string) string {
+	var set []string
+	for _, setting := range settings {
+		if setting != "" {
+			set = append(set, setting)
+		}
+	}
+	return strings.Join(set, ", ")
+}
+
+// riskySettings lists everything in Viber00t.toml that reaches outside the
+// container: host paths, privileges, ports, host credentials and commands run
+// on the host. A project with none of them needs no trust.
+func riskySettings(config *Config) []string {
+	var settings []string
+	if config.Project.Privileged {
+		settings = append(settings, "privileged: --privileged, SELinux labels disabled, docker socket mounted")
+	}
+	for _, vol := range config.Volumes {
+		if vol.Source != "" && vol.Target != "" {
+			settings = append(settings, fmt.Sprintf("volume: %s → %s (rw)", vol.Source, vol.Target))
+		}
+	}
+	for _, port := range config.Ports {
+		if port.declared() {
+			settings = append(settings, port.describe())
+		}
+	}
+	for _, service := range declaredServices(config) {
+		settings = append(settings, service.describe())
+	}
+	settings = append(settings, config.Hooks.describe()...)
+
+	names := make([]string, 0, len(config.Mounts))
+	for name := range config.Mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		settings = append(settings, fmt.Sprintf("mount %s: %s", name, config.Mounts[name]))
+	}
+
+	if ssh := joinSettings(
+		describeBool("agent", config.SSH.Agent),
+		describeBool("confirm", config.SSH.Confirm),
+		describeBool("log", config.SSH.Log),
+	); ssh != "" || config.SSH.AllowedKeys != nil {
+		if config.SSH.AllowedKeys != nil {
+			ssh = joinSettings(ssh, "allowed_keys = ["+strings.Join(config.SSH.AllowedKeys, ", ")+"]")
+		}
+		settings = append(settings, "ssh: "+ssh)
+	}
+	if credentials := joinSettings(describeBool("bridge", config.Credentials.Bridge)); credentials != "" || config.Credentials.Hosts != nil {
+		if config.Credentials.Hosts != nil {
+			credentials = joinSettings(credentials, "hosts = ["+strings.Join(config.Credentials.Hosts, ", ")+"]")
+		}
+		settings = append(settings, "credentials: "+credentials)
+	}
+	if gpg := joinSettings(
+		describeBool("forward", config.GPG.Forward),
+		describeBool("sign_commits", config.GPG.SignCommits),
+		describeBool("confirm", config.GPG.Confirm),
+	); gpg != "" || config.GPG.SigningKey != "" {
+		if config.GPG.SigningKey != "" {
+			gpg = joinSettings(gpg, "signing_key = "+config.GPG.SigningKey)
+		}
+		settings = append(settings, "gpg: "+gpg)
+	}
+
+	if network := config.Network.describe(); network != "" {
+		settings = append(settings, "network: "+network)
+	}
+	if mask := config.Mask.describe(); mask != "" {
+		settings = append(settings, "mask: "+mask)
+	}
+	if security := config.Security.describe(); security != "" {
+		settings = append(settings, "security: "+security)
+	}
+
+	for _, spec := range declaredSecrets(config.Secrets) {
+		settings = append(settings, fmt.Sprintf("secret %s: from %s → %s", spec.Name, spec.source(), spec.destination()))
+	}
+	return settings
+}
+
+func trustHash(settings []string) string {
+	h := sha256.Sum256([]byte(strings.Join(settings, "\n")))
+	return hex.EncodeToString(h[:])
+}
+
+func printRiskySettings(settings []string) {
+	for _, setting := range settings {
+		fmt.Printf("  \033[33m•\033[0m %s\n", setting)
+	}
+}
+
+// requireTrust makes sure the user has accepted the project's current risky
+// settings before any of them are honored, asking on the terminal if not.
+func requireTrust(config *Config, cwd string) {
+	settings := riskySettings(config)
+	if len(settings) == 0 {
+		return
+	}
+
+	store, err := loadTrustStore()
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	key := trustKey(cwd)
+	hash := trustHash(settings)
+	entry, known := store[key]
+	if known && entry.Hash == hash {
+		return
+	}
+
+	if known {
+		fmt.Println("\033[33m⚠\033[0m  Viber00t.toml changed since you trusted it. It now asks for:")
+	} else {
+		fmt.Println("\033[33m⚠\033[0m  This Viber00t.toml is not trusted yet. It asks for:")
+	}
+	printRiskySettings(settings)
+
+	if dryRun {
+		fmt.Println("\033[90mDry run, nothing is executed. Run 'viber00t trust' to accept.\033[0m")
+		return
+	}
+	if !isTerminal(os.Stdin) {
+		fmt.Println("\033[31m✗\033[0m Refusing to run an untrusted project without a terminal. Run 'viber00t trust' first.")
+		os.Exit(1)
+	}
+	if !confirm("Trust these settings for " + shortenHome(key) + "?") {
+		fmt.Println("\033[31m✗\033[0m Not trusted, aborting")
+		os.Exit(1)
+	}
+
+	store[key] = TrustEntry{Hash: hash, TrustedAt: time.Now(), Settings: settings}
+	if err := saveTrustStore(store); err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to save trust store:", err)
+	}
+}
+
+func trustProject(args []string) {
+	if len(args) > 0 && (args[0] == "--list" || args[0] == "-l") {
+		listTrusted()
+		return
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("\033[31m✗\033[0m No Viber00t.toml found. Run 'viber00t init' first.")
+		os.Exit(1)
+	}
+	cwd, _ := os.Getwd()
+	key := trustKey(cwd)
+	settings := riskySettings(config)
+
+	store, err := loadTrustStore()
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	store[key] = TrustEntry{Hash: trustHash(settings), TrustedAt: time.Now(), Settings: settings}
+	if err := saveTrustStore(store); err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to save trust store:", err)
+	}
+
+	if len(settings) == 0 {
+		fmt.Printf("\033[32m✓\033[0m Trusted %s (no risky settings)\n", shortenHome(key))
+		return
+	}
+	fmt.Printf("\033[32m✓\033[0m Trusted %s with:\n", shortenHome(key))
+	printRiskySettings(settings)
+}
+
+func untrustProject(args []string) {
+	cwd, _ := os.Getwd()
+	if len(args) > 0 {
+		cwd = expandPath(args[0])
+	}
+	key := trustKey(cwd)
+
+	store, err := loadTrustStore()
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	if _, ok := store[key]; !ok {
+		fmt.Printf("\033[33m⚠\033[0m  %s is not trusted\n", shortenHome(key))
+		return
+	}
+	delete(store, key)
+	if err := saveTrustStore(store); err != nil {
+		log.Fatal("\033[31m✗\033[0m Failed to save trust store:", err)
+	}
+	fmt.Printf("\033[32m✓\033[0m %s is no longer trusted\n", shortenHome(key))
+}
+
+func listTrusted() {
+	store, err := loadTrustStore()
+	if err != nil {
+		log.Fatal("\033[31m✗\033[0m ", err)
+	}
+	if len(store) == 0 {
+		fmt.Println("\033[90mNo trusted projects\033[0m")
+		return
+	}
+
+	paths := make([]string, 0, len(store))
+	for path := range store {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		entry := store[path]
+		fmt.Printf("\033[36m%s\033[0m \033[90mtrusted %s\033[0m\n", shortenHome(path), entry.TrustedAt.Format("2006-01-02 15:04"))
+		printRiskySettings(entry.Settings)
+	}
+}
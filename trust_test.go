@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func decodeConfig(t *testing.T, input string) *Config {
+	t.Helper()
+	var config Config
+	if _, err := toml.Decode(input, &config); err != nil {
+		t.Fatal(err)
+	}
+	return &config
+}
+
+func TestRiskySettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // substrings of the listed settings, none when empty
+	}{
+		{"safe", "[project]\nname = \"demo\"\nagent = \"claude\"\n", nil},
+		{"volume", "[[volumes]]\nsource = \"~/data\"\ntarget = \"/data\"\n", []string{"volume: ~/data → /data (rw)"}},
+		{"mount", "[mounts]\nssh = \"rw\"\n", []string{"mount ssh: rw"}},
+		{"ssh", "[ssh]\nagent = true\nallowed_keys = [\"SHA256:abc\"]\n", []string{"ssh: agent = true, allowed_keys = [SHA256:abc]"}},
+		{"credentials", "[credentials]\nhosts = [\"github.com\"]\n", []string{"credentials: hosts = [github.com]"}},
+		{"gpg", "[gpg]\nforward = true\nsigning_key = \"ABCD\"\n", []string{"gpg: forward = true, signing_key = ABCD"}},
+		{"secret", "[[secrets]]\nname = \"token\"\nfrom_env = \"TOKEN\"\n", []string{"secret token:"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := riskySettings(decodeConfig(t, tt.input))
+			if tt.want == nil {
+				if len(settings) != 0 {
+					t.Errorf("settings %q, want none", settings)
+				}
+				return
+			}
+			joined := strings.Join(settings, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(joined, want) {
+					t.Errorf("settings %q, want %q", settings, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrustHash(t *testing.T) {
+	base := "[project]\nname = \"demo\"\nagent = \"claude\"\n[gpg]\nforward = true\nsigning_key = \"ABCD\"\n"
+	hash := trustHash(riskySettings(decodeConfig(t, base)))
+
+	// Only the risky settings count, anything else may change freely
+	if got := trustHash(riskySettings(decodeConfig(t, strings.Replace(base, "claude", "codex", 1)))); got != hash {
+		t.Errorf("changing the agent changed the trust hash")
+	}
+	if got := trustHash(riskySettings(decodeConfig(t, strings.Replace(base, "ABCD", "EF01", 1)))); got == hash {
+		t.Errorf("changing the signing key kept the trust hash")
+	}
+	if got := trustHash(riskySettings(decodeConfig(t, base+"[mounts]\nssh = \"ro\"\n"))); got == hash {
+		t.Errorf("adding a mount kept the trust hash")
+	}
+}
+
+func TestContainerArgsDryRun(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+	t.Setenv("SSH_ASKPASS", "")
+	dryRun = true
+	defer func() { dryRun = false }()
+
+	// Everything here needs trust and a host-side listener for a real run
+	config := decodeConfig(t, `
+[project]
+name = "demo"
+[ssh]
+confirm = true
+[credentials]
+hosts = ["github.com"]
+[gpg]
+forward = true
+signing_key = "ABCDEF"
+confirm = true
+[network]
+mode = "allowlist"
+[forward]
+enabled = true
+`)
+	session := &Session{ID: "test-dry-run"}
+	os.MkdirAll(session.Dir(), 0700)
+	args, err := containerArgs(config, &GlobalConfig{}, session, t.TempDir(), "viber00t-demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.runCleanups()
+
+	command := strings.Join(args, " ")
+	for _, want := range []string{"SSH_AUTH_SOCK=", "credential.helper", "user.signingkey", "HTTPS_PROXY="} {
+		if !strings.Contains(command, want) {
+			t.Errorf("args lack %q: %s", want, command)
+		}
+	}
+	filepath.Walk(session.Dir(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("dry run left %s behind", path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"regexp"
+	"strings"
+)
+
+// containerUser is who the agent runs as inside the container.
+type containerUser struct {
+	Name string
+	UID  string
+	GID  string
+	Home string
+}
+
+var validUserName = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+var rootUser = containerUser{Name: "root", UID: "0", GID: "0", Home: "/root"}
+
+func checkUserMode(mode, origin string) error {
+	if mode != "" && mode != "root" && mode != "host" {
+		return fmt.Errorf("%s: user must be \"root\" or \"host\", got %q", origin, mode)
+	}
+	return nil
+}
+
+// resolveUser returns the container user for the project: root (the default),
+// or with user = "host" a user mirroring the host account.
+func resolveUser(config *Config, globalConfig *GlobalConfig) containerUser {
+	mode := config.Project.User
+	if mode == "" {
+		mode = globalConfig.DefaultUser
+	}
+	if mode != "host" {
+		return rootUser
+	}
+
+	current, err := user.Current()
+	if err != nil || current.Uid == "0" {
+		return rootUser
+	}
+	name := strings.ToLower(current.Username)
+	if !validUserName.MatchString(name) {
+		name = "viber"
+	}
+	return containerUser{Name: name, UID: current.Uid, GID: current.Gid, Home: "/home/" + name}
+}
+
+func (u containerUser) isRoot() bool {
+	return u.UID == "0"
+}
+
+// retarget moves a path under /root to the user's home.
+func (u containerUser) retarget(path string) string {
+	if u.isRoot() || (path != "/root" && !strings.HasPrefix(path, "/root/")) {
+		return path
+	}
+	return u.Home + strings.TrimPrefix(path, "/root")
+}
+
+// userArgs maps the host user into the container: onto root by default, or
+// onto the same UID when the image has a matching user.
+func (u containerUser) args() []string {
+	if u.isRoot() {
+		return []string{"--userns=keep-id:uid=0,gid=0"}
+	}
+	return []string{
+		"--userns=keep-id",
+		"--user", u.UID + ":" + u.GID,
+		"-e", "HOME=" + u.Home,
+		"-e", "USER=" + u.Name,
+	}
+}
+
+// dockerfile creates the user in the project image, replacing any image user
+// that already holds the UID, GID or name (ubuntu ships one with 1000).
+func (u containerUser) dockerfile() string {
+	if u.isRoot() {
+		return ""
+	}
+	return fmt.Sprintf(`
+# Non-root user matching the host account
+RUN if getent passwd %[2]s >/dev/null; then userdel -r "$(getent passwd %[2]s | cut -d: -f1)" 2>/dev/null || true; fi && \
+    if getent group %[3]s >/dev/null; then groupdel "$(getent group %[3]s | cut -d: -f1)" 2>/dev/null || true; fi && \
+    { userdel -r %[1]s 2>/dev/null; groupdel %[1]s 2>/dev/null; true; } && \
+    groupadd -g %[3]s %[1]s && \
+    useradd -m -u %[2]s -g %[3]s -d %[4]s -s /bin/bash %[1]s && \
+    mkdir -p /etc/sudoers.d && \
+    echo '%[1]s ALL=(ALL) NOPASSWD:ALL' > /etc/sudoers.d/viber00t && \
+    chmod 0440 /etc/sudoers.d/viber00t
+ENV PATH="%[4]s/.local/bin:${PATH}"
+`, u.Name, u.UID, u.GID, u.Home)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os/user"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveUser(t *testing.T) {
+	globalConfig := &GlobalConfig{}
+	config := &Config{}
+	if got := resolveUser(config, globalConfig); got != rootUser {
+		t.Errorf("default user %+v, want root", got)
+	}
+
+	globalConfig.DefaultUser = "host"
+	config.Project.User = "root"
+	if got := resolveUser(config, globalConfig); got != rootUser {
+		t.Errorf("project user = \"root\" gave %+v", got)
+	}
+
+	config.Project.User = ""
+	got := resolveUser(config, globalConfig)
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	if current.Uid == "0" {
+		// Root on the host stays root in the container
+		if got != rootUser {
+			t.Errorf("host user as root gave %+v", got)
+		}
+		return
+	}
+	if got.UID != current.Uid || got.GID != current.Gid || got.Home != "/home/"+got.Name || !validUserName.MatchString(got.Name) {
+		t.Errorf("host user %+v for uid %s", got, current.Uid)
+	}
+}
+
+func TestCheckUserMode(t *testing.T) {
+	for _, mode := range []string{"", "root", "host"} {
+		if err := checkUserMode(mode, "test"); err != nil {
+			t.Errorf("%q: %v", mode, err)
+		}
+	}
+	if err := checkUserMode("1000", "test"); err == nil {
+		t.Errorf("user = \"1000\" accepted")
+	}
+}
+
+func TestContainerUser(t *testing.T) {
+	dev := containerUser{Name: "dev", UID: "1000", GID: "1000", Home: "/home/dev"}
+	tests := []struct {
+		path, root, dev string
+	}{
+		{"/root", "/root", "/home/dev"},
+		{"/root/.cache", "/root/.cache", "/home/dev/.cache"},
+		{"/rootfs", "/rootfs", "/rootfs"},
+		{"/tmp", "/tmp", "/tmp"},
+	}
+	for _, tt := range tests {
+		if got := rootUser.retarget(tt.path); got != tt.root {
+			t.Errorf("root retarget(%q) = %q, want %q", tt.path, got, tt.root)
+		}
+		if got := dev.retarget(tt.path); got != tt.dev {
+			t.Errorf("dev retarget(%q) = %q, want %q", tt.path, got, tt.dev)
+		}
+	}
+
+	if got, want := rootUser.args(), []string{"--userns=keep-id:uid=0,gid=0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("root args %q, want %q", got, want)
+	}
+	want := []string{"--userns=keep-id", "--user", "1000:1000", "-e", "HOME=/home/dev", "-e", "USER=dev"}
+	if got := dev.args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("dev args %q, want %q", got, want)
+	}
+
+	if rootUser.dockerfile() != "" {
+		t.Errorf("root gets a dockerfile snippet")
+	}
+	if snippet := dev.dockerfile(); !strings.Contains(snippet, "useradd -m -u 1000 -g 1000 -d /home/dev -s /bin/bash dev") {
+		t.Errorf("dockerfile snippet %q", snippet)
+	}
+}